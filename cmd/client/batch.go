@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"grpc-benchmark-study/internal/calculation"
+	"grpc-benchmark-study/internal/loadgen"
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/tracking"
+
+	"google.golang.org/grpc/metadata"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// defaultBatchSize is the number of calculations per stream of a batch run
+// that does not set it.
+const defaultBatchSize = 10
+
+// batchStream is the PerformCalculationBatch stream a worker is filling.
+type batchStream struct {
+	stream pb.CalculatorService_PerformCalculationBatchClient
+	conn   int
+	labels []string // tracker labels of every entry sent on the stream
+	ids    []int32  // tracker entries added for the stream
+	sent   int
+	broken bool // a send failed and was counted as the stream's error
+}
+
+// runBatchMode spawns worker goroutines that each open a
+// PerformCalculationBatch stream, send cfg.BatchSize calculations on it and
+// close it to receive all results at once, then start the next stream. The
+// latency of a calculation runs from its own send to the batch result, so it
+// includes the wait for the rest of the batch.
+func runBatchMode(pool *connPool, cfg report.RunConfig, opts runOptions) *report.Report {
+	clientID := cfg.ClientID
+	run := startRun(cfg, opts)
+	if run.profile != nil {
+		log.Printf("Running in batch mode with client-id=%s, workers=%d, batch size=%d, conns=%d (%s), load=%s (open-loop), total transactions=%d",
+			clientID, cfg.Workers, cfg.BatchSize, cfg.Conns, cfg.ConnPolicy, run.profile, cfg.Transactions)
+	} else {
+		log.Printf("Running in batch mode with client-id=%s, workers=%d, batch size=%d, conns=%d (%s), interval=%dms, total transactions=%d",
+			clientID, cfg.Workers, cfg.BatchSize, cfg.Conns, cfg.ConnPolicy, cfg.IntervalMs, cfg.Transactions)
+	}
+	conns := newConnPicker(pool.clients(cfg.Conns), cfg.ConnPolicy)
+	tracker, meter := run.tracker, run.meter
+
+	run.work(func(workerID int, tasks <-chan loadgen.Slot) {
+		var batch *batchStream
+		for task := range tasks {
+			if batch == nil {
+				var err error
+				batch, err = openBatch(pool, conns, workerID, clientID)
+				if err != nil {
+					meter.AddError("send")
+					if *verbose {
+						log.Printf("Worker %d: error opening batch stream for transaction %d: %v", workerID, task.Seq, err)
+					}
+					run.pace()
+					continue
+				}
+			}
+			calc := run.calc(task.Seq)
+			tracker.AddLabeled(calc, task.Intended, batch.labels...)
+			batch.ids = append(batch.ids, calc.ID)
+			if err := batch.stream.Send(signedCalc(&calc)); err != nil {
+				// The stream broke; closing it reports why.
+				meter.AddError(errorKind(err, "send"))
+				batch.broken = true
+				closeBatch(batch, conns, tracker, meter)
+				batch = nil
+			} else {
+				meter.AddRequest()
+				batch.sent++
+				if batch.sent == cfg.BatchSize {
+					closeBatch(batch, conns, tracker, meter)
+					batch = nil
+				}
+			}
+			run.pace()
+		}
+		// The schedule ended with a partial batch.
+		if batch != nil {
+			closeBatch(batch, conns, tracker, meter)
+		}
+	})
+
+	// Every result arrived with its batch—stop the meter.
+	run.stopMeter()
+	return run.finish()
+}
+
+// openBatch opens a PerformCalculationBatch stream on the connection picked
+// for workerID.
+func openBatch(pool *connPool, conns *connPicker, workerID int, clientID string) (*batchStream, error) {
+	conn := conns.pick(workerID)
+	// Generate (or re-use) JWT token as per mode.
+	jwtToken := getJWTToken(clientID)
+	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken, calculation.CodecHeader, payloadCodec.Name())
+	stream, err := conns.clients[conn].PerformCalculationBatch(metadata.NewOutgoingContext(context.Background(), md))
+	if err != nil {
+		conns.done(conn)
+		return nil, err
+	}
+	labels := slices.Concat(conns.labels[conn], pool.backendLabel(stream.Context()))
+	return &batchStream{stream: stream, conn: conn, labels: labels}, nil
+}
+
+// closeBatch closes the sending side of batch and records the results the
+// server returns for it. All results of a batch arrive at once, so its
+// entries that are still unanswered afterwards are given up as lost.
+func closeBatch(batch *batchStream, conns *connPicker, tracker *tracking.Tracker, meter *tracking.Meter) {
+	resp, err := batch.stream.CloseAndRecv()
+	conns.done(batch.conn)
+	if err != nil {
+		log.Printf("Batch of %d calculations failed: %v", batch.sent, err)
+		// A stream whose send failed already counts as one error.
+		if !batch.broken {
+			meter.AddError(errorKind(err, "batch"))
+		}
+		tracker.MarkLost(batch.ids...)
+		return
+	}
+	if *verbose {
+		log.Printf("Received batch of %d results", len(resp.GetResults()))
+	}
+	for _, result := range resp.GetResults() {
+		recordDirect(result, nil, tracker, meter)
+	}
+	if n := tracker.MarkLost(batch.ids...); n > 0 {
+		log.Printf("Batch of %d calculations left %d unanswered", batch.sent, n)
+	}
+}
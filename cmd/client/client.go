@@ -5,12 +5,19 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
+	"fmt"
 	"grpc-benchmark-study/internal/calculation"
 	"grpc-benchmark-study/internal/jwtutil" // Assumed JWT utility package
+	"grpc-benchmark-study/internal/loadgen"
 	"grpc-benchmark-study/internal/messagesigning"
+	"grpc-benchmark-study/internal/report"
 	"grpc-benchmark-study/internal/resources"
 	"grpc-benchmark-study/internal/tracking"
 	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,28 +25,77 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator" // Update with your actual module/import path.
 )
 
-var requestCounter int64
-var responseCounter int64
+// slowEntryLimit caps how many entries above -latency-gt are kept for the summary.
+const slowEntryLimit = 1000
 
 // Global variables for JWT mode.
 var (
 	jwtGenMode string // "once" or "every"
-	storedJWT  string
+	jwtMu      sync.Mutex
+	storedJWT  = map[string]string{} // "once" tokens by client ID
 )
 
+// payloadCodec encodes the calculations in signed payloads, and runPadding
+// is the padding every calculation carries. Both are set from the run's
+// config like jwtGenMode.
+var (
+	payloadCodec calculation.Codec
+	runPadding   []byte
+)
+
+// tooLargeLogged is set once a message size error of the run was logged.
+var tooLargeLogged atomic.Bool
+
+// messageTooLarge reports whether err is gRPC refusing a message above the
+// max message size of its sender or receiver. gRPC reports both as
+// ResourceExhausted, like the server's reject overflow policy, so they are
+// told apart by the message, e.g. "grpc: received message larger than max
+// (5592514 vs. 4194304)".
+func messageTooLarge(err error) bool {
+	st := status.Convert(err)
+	return st.Code() == codes.ResourceExhausted && strings.Contains(st.Message(), "larger than max")
+}
+
+// errorKind returns the meter error kind of err: "too_large" for a message
+// size error, fallback otherwise. The first message size error of a run is
+// logged even without -verbose, since the rest of the run fails the same way.
+func errorKind(err error, fallback string) string {
+	if !messageTooLarge(err) {
+		return fallback
+	}
+	if !tooLargeLogged.Swap(true) {
+		log.Printf("Message exceeds the max message size: %v", err)
+	}
+	return "too_large"
+}
+
 // getJWTToken returns a JWT token string according to the selected mode.
 func getJWTToken(clientID string) string {
 	if jwtGenMode == "once" {
-		return storedJWT
+		jwtMu.Lock()
+		defer jwtMu.Unlock()
+		if token, ok := storedJWT[clientID]; ok {
+			return token
+		}
+		storedJWT[clientID] = generateJWTToken(clientID)
+		log.Printf("Generated JWT token (once mode)")
+		return storedJWT[clientID]
 	}
 	// For "every", generate a new token with simple claims.
+	return generateJWTToken(clientID)
+}
+
+func generateJWTToken(clientID string) string {
 	claims := jwt.MapClaims{
 		"sub": clientID,
 		"iat": time.Now().Unix(),
@@ -55,54 +111,209 @@ func getJWTToken(clientID string) string {
 var verbose *bool
 
 func main() {
+	// Sub-commands come first, e.g. "client run-scenario study.yaml" or "client matrix -workers=1,2".
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "run-scenario":
+			runScenarioCommand(os.Args[2:])
+		case "matrix":
+			runMatrixCommand(os.Args[2:])
+		case "compare":
+			runCompareCommand(os.Args[2:])
+		default:
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+		return
+	}
+
 	// Command-line flags.
-	host := flag.String("host", "localhost:50051", "Server host:port")
-	mode := flag.String("mode", "unary", "Mode: unary or bidirectional")
-	workers := flag.Int("workers", 1, "Number of workers (only in unary mode)")
+	hosts := addHostFlags(flag.CommandLine)
+	mode := flag.String("mode", "unary", "Mode: unary, unary-direct, batch or bidirectional")
+	workers := flag.Int("workers", 1, "Number of sending workers")
+	streams := flag.Int("streams", 1, "Number of bidirectional streams (only in bidirectional mode)")
+	batchSize := flag.Int("batch-size", defaultBatchSize, "Number of calculations sent per stream before closing it (only in batch mode)")
+	conns := flag.Int("conns", 1, "Number of connections the unary calls or bidirectional streams are spread over")
+	connPolicy := flag.String("conn-policy", policyRoundRobin, "Distribution of unary calls over connections: round-robin, per-worker or least-outstanding")
+	streamPolicy := flag.String("stream-policy", policyRoundRobin, "Distribution of sends over streams: round-robin or least-outstanding")
+	acks := flag.Bool("acks", false, "Acknowledge every result: with ack frames in bidirectional mode, with the Ack RPC in unary mode")
+	reconnectBackoff := flag.Duration("reconnect-backoff", defaultReconnectBackoff, "Wait before reopening a broken stream, doubled after every failed attempt")
+	reconnectMaxBackoff := flag.Duration("reconnect-max-backoff", defaultReconnectMaxBackoff, "Longest wait between two attempts to reopen a broken stream")
+	unanswered := flag.String("unanswered", unansweredLost, "What to do with the transactions a broken stream left unanswered: resend, lost or keep (wait for a mailbox replay)")
+	resultTimeout := flag.Duration("result-timeout", defaultResultTimeout, "Give up on a unary result as lost once it is this late, e.g. because the server dropped it")
 	interval := flag.Int("interval", 1000, "Interval between transactions in milliseconds")
+	rateFlag := flag.String("rate", "", "Open-loop target arrival rate, e.g. 500/s (overrides -interval)")
+	profileFlag := flag.String("profile", "", "Open-loop load profile, e.g. ramp:from=100/s,to=2000/s,over=60s (overrides -rate)")
+	sloP99 := flag.Float64("slo-p99", 0, "SLO on the per-second p99 latency in ms for the saturation report (0 disables)")
+	sloErrorRate := flag.Float64("slo-error-rate", 0, "SLO on the error rate in percent for the saturation report (0 disables)")
 	xFlag := flag.Int("x", 3, "Value of the X number")
 	yFlag := flag.Int("y", 1, "Value of the Y number")
-	operationFlag := flag.String("operation", "ADD", "Operation: ADD, SUBTRACT, ISPRIME")
-	transactions := flag.Int("transactions", 10, "Number of transactions (per worker in unary mode, total in bidirectional)")
+	operationFlag := flag.String("operation", "ADD", "Operation: ADD, SUBTRACT, ISPRIME, or a weighted mix such as ADD:3,ISPRIME:1")
+	transactions := flag.Int("transactions", 10, "Total number of transactions shared by all workers, unlimited by default with -duration or -profile")
+	duration := flag.Duration("duration", 0, "Run for this long, including warm-up and cool-down (0 for no limit)")
+	warmup := flag.Duration("warmup", 0, "Warm-up period at the start of the run whose samples are left out of the stats")
+	cooldown := flag.Duration("cooldown", 0, "Cool-down period at the end of a -duration run whose samples are left out of the stats")
 	clientID := flag.String("client-id", "default-client", "Client ID")
-	latencyGt := flag.Int("latency-gt", 5, "Only print entries with latency greater than this (ms)")
 	jwtGen := flag.String("jwt-gen", "once", "JWT generation mode: once or every")
-	verbose = flag.Bool("verbose", false, "Verbose output")
+	codec := flag.String("codec", calculation.CodecJSON, "Codec of the calculations in signed payloads, one the server accepts: "+strings.Join(calculation.CodecNames(), ", "))
+	flag.String("payload", "", "Deprecated: use -codec")
+	payloadBytes := flag.Int("payload-bytes", 0, "Padding size in bytes of every calculation")
+	padding := flag.String("padding", calculation.PaddingRandom, "Padding content: random or compressible")
+	responseBytes := flag.Int("response-bytes", 0, "Padding size in bytes of every result (0 echoes the request's padding)")
+	out := addOutputFlags(flag.CommandLine)
 	flag.Parse()
+	deprecatedAlias(flag.CommandLine, "payload", "codec")
 
-	// Set the JWT generation mode.
-	jwtGenMode = *jwtGen
-	if jwtGenMode != "once" && jwtGenMode != "every" {
-		log.Fatalf("Invalid jwt-gen mode: %s. Allowed values are 'once' or 'every'.", jwtGenMode)
+	// A duration or profile based run only stops after -transactions if it was given explicitly.
+	if (*duration > 0 || *profileFlag != "") && !flagSet(flag.CommandLine, "transactions") {
+		*transactions = 0
 	}
-	err := jwtutil.LoadKeys("jwt/jwt.key", "jwt/jwt.pub")
-	if err != nil {
-		log.Fatalf("Unable to load private key: %v", err)
+
+	// An empty rate keeps the closed-loop behaviour (send, then sleep -interval).
+	var rate float64
+	if *rateFlag != "" {
+		var err error
+		rate, err = loadgen.ParseRate(*rateFlag)
+		if err != nil {
+			log.Fatalf("Invalid rate: %v", err)
+		}
 	}
 
-	// If "once", generate the JWT token at startup.
-	if jwtGenMode == "once" {
-		claims := jwt.MapClaims{
-			"sub": *clientID,
-			"iat": time.Now().Unix(),
-			"exp": time.Now().Add(1 * time.Hour).Unix(),
+	cfg := report.RunConfig{
+		Mode:         *mode,
+		ClientID:     *clientID,
+		Workers:      *workers,
+		Streams:      *streams,
+		Conns:        *conns,
+		ConnPolicy:   *connPolicy,
+		StreamPolicy: *streamPolicy,
+		BatchSize:    *batchSize,
+		Acks:         *acks,
+		Reconnect: report.Reconnect{
+			Backoff:    report.Duration(*reconnectBackoff),
+			MaxBackoff: report.Duration(*reconnectMaxBackoff),
+			Unanswered: *unanswered,
+		},
+		ResultTimeout: report.Duration(*resultTimeout),
+		IntervalMs:    *interval,
+		Rate:          rate,
+		Profile:       *profileFlag,
+		Transactions:  *transactions,
+		Duration:      report.Duration(*duration),
+		Warmup:        report.Duration(*warmup),
+		Cooldown:      report.Duration(*cooldown),
+		Operation:     *operationFlag,
+		JWTGen:        *jwtGen,
+		Codec:         *codec,
+		PayloadBytes:  *payloadBytes,
+		Padding:       *padding,
+		ResponseBytes: *responseBytes,
+		X:             *xFlag,
+		Y:             *yFlag,
+		SLO:           report.SLO{P99Ms: *sloP99, ErrorRatePct: *sloErrorRate},
+	}
+
+	setupSecurity()
+	pool := hosts.pool()
+	defer pool.Close()
+
+	rep := executeRun(pool, cfg, out.runOptions())
+	writeReports(rep, out)
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
 		}
-		token, err := jwtutil.GenerateToken(claims)
-		if err != nil {
-			log.Fatalf("Failed to generate JWT token: %v", err)
+	})
+	return set
+}
+
+// deprecatedAlias copies the value of the deprecated flag old to its
+// replacement, unless the replacement was given too, so that older scripts
+// keep working.
+func deprecatedAlias(fs *flag.FlagSet, old, replacement string) {
+	if !flagSet(fs, old) {
+		return
+	}
+	log.Printf("-%s is deprecated, use -%s instead", old, replacement)
+	if !flagSet(fs, replacement) {
+		fs.Set(replacement, fs.Lookup(old).Value.String())
+	}
+}
+
+// outputFlags are the flags shared by every command that executes runs.
+type outputFlags struct {
+	latencyGt  *int
+	progress   *bool
+	reportJSON *string
+	reportCSV  *string
+}
+
+func addOutputFlags(fs *flag.FlagSet) *outputFlags {
+	out := &outputFlags{
+		latencyGt:  fs.Int("latency-gt", 5, "Only print entries with latency greater than this (ms)"),
+		progress:   fs.Bool("progress", false, "Print throughput and latency for every second while running"),
+		reportJSON: fs.String("report-json", "", "Write the run report as JSON to this file"),
+		reportCSV:  fs.String("report-csv", "", "Write the run report as CSV to this file"),
+	}
+	verbose = fs.Bool("verbose", false, "Verbose output")
+	return out
+}
+
+func (o *outputFlags) runOptions() runOptions {
+	return runOptions{latencyThreshold: *o.latencyGt, progress: *o.progress}
+}
+
+// reportWriter is implemented by report.Report and report.Suite.
+type reportWriter interface {
+	WriteJSON(path string) error
+	WriteCSV(path string) error
+}
+
+// writeReports writes rep to the files requested with -report-json and -report-csv.
+func writeReports(rep reportWriter, out *outputFlags) {
+	if *out.reportJSON != "" {
+		if err := rep.WriteJSON(*out.reportJSON); err != nil {
+			log.Fatalf("Failed to write JSON report: %v", err)
 		}
-		storedJWT = token
-		log.Printf("Generated JWT token (once mode)")
+		log.Printf("Wrote JSON report to %s", *out.reportJSON)
+	}
+	if *out.reportCSV != "" {
+		if err := rep.WriteCSV(*out.reportCSV); err != nil {
+			log.Fatalf("Failed to write CSV report: %v", err)
+		}
+		log.Printf("Wrote CSV report to %s", *out.reportCSV)
+	}
+}
+
+// setupSecurity loads the JWT keys and the message signing certificates.
+func setupSecurity() {
+	err := jwtutil.LoadKeys("jwt/jwt.key", "jwt/jwt.pub")
+	if err != nil {
+		log.Fatalf("Unable to load private key: %v", err)
 	}
 
 	//Message Signing
 	err = messagesigning.LoadSigner("cms/signer.crt", "cms/signer.key", "cms/ca.crt")
 	if err != nil {
+		log.Fatalf("Failed to load signer key: %v", err)
 	}
+}
+
+// dial creates the mutual TLS gRPC connection to host with opts.
+func dial(host string, opts ...grpc.DialOption) *grpc.ClientConn {
+	conn, err := grpc.Dial(host, append(opts, grpc.WithTransportCredentials(transportCredentials()))...)
 	if err != nil {
-		log.Fatalf("Failed to load signer key: %v", err)
+		log.Fatalf("Failed to connect to %s: %v", host, err)
 	}
+	return conn
+}
 
+// transportCredentials returns the client's mutual TLS credentials.
+func transportCredentials() credentials.TransportCredentials {
 	// --- TLS Setup ---
 	// Load client certificate and key.
 	certBytes, err := resources.Certs.ReadFile("certs/client.crt")
@@ -133,340 +344,897 @@ func main() {
 		ServerName:   "localhost", //Ensures it works even on different hosts, i.e. cloud env
 		// Optionally set ServerName if needed.
 	}
-	creds := credentials.NewTLS(tlsConfig)
 	// --- End TLS Setup ---
+	return credentials.NewTLS(tlsConfig)
+}
 
-	// Create gRPC connection with TLS.
-	conn, err := grpc.Dial(*host, grpc.WithTransportCredentials(creds))
-	if err != nil {
-		log.Fatalf("Failed to connect to %s: %v", *host, err)
+// executeRun executes one run in the mode selected by cfg and returns its report.
+func executeRun(pool *connPool, cfg report.RunConfig, opts runOptions) *report.Report {
+	// Set the JWT generation mode.
+	jwtGenMode = cfg.JWTGen
+	if jwtGenMode != "once" && jwtGenMode != "every" {
+		log.Fatalf("Invalid jwt-gen mode: %s. Allowed values are 'once' or 'every'.", jwtGenMode)
+	}
+	if cfg.Codec == "" {
+		cfg.Codec = calculation.CodecJSON
+	}
+	var err error
+	if payloadCodec, err = calculation.GetCodec(cfg.Codec); err != nil {
+		log.Fatalf("Invalid codec: %v", err)
+	}
+	if cfg.Padding == "" {
+		cfg.Padding = calculation.PaddingRandom
+	}
+	if cfg.ResponseBytes < 0 {
+		log.Fatalf("Invalid response size: %d", cfg.ResponseBytes)
+	}
+	if runPadding, err = calculation.NewPadding(cfg.PayloadBytes, cfg.Padding); err != nil {
+		log.Fatalf("Invalid padding: %v", err)
+	}
+	tooLargeLogged.Store(false)
+	// A bounded load profile runs for its own length unless a duration is given.
+	if cfg.Profile != "" && cfg.Duration == 0 {
+		cfg.Duration = report.Duration(loadProfile(cfg).Length())
+	}
+	if cfg.Transactions == 0 && cfg.Duration == 0 {
+		log.Fatalf("Either transactions or duration must be set")
+	}
+	if cfg.Cooldown > 0 && cfg.Duration == 0 {
+		log.Fatalf("A cool-down needs a duration")
+	}
+	if cfg.Duration > 0 && cfg.Warmup+cfg.Cooldown >= cfg.Duration {
+		log.Fatalf("Warm-up (%s) and cool-down (%s) leave nothing to measure in %s",
+			time.Duration(cfg.Warmup), time.Duration(cfg.Cooldown), time.Duration(cfg.Duration))
 	}
-	defer conn.Close()
 
-	client := pb.NewCalculatorServiceClient(conn)
+	if cfg.Conns < 1 {
+		cfg.Conns = 1
+	}
+	if cfg.Reconnect.Backoff <= 0 {
+		cfg.Reconnect.Backoff = report.Duration(defaultReconnectBackoff)
+	}
+	if cfg.Reconnect.MaxBackoff <= 0 {
+		cfg.Reconnect.MaxBackoff = report.Duration(defaultReconnectMaxBackoff)
+	}
+	cfg.Reconnect.MaxBackoff = max(cfg.Reconnect.MaxBackoff, cfg.Reconnect.Backoff)
+	if cfg.Reconnect.Unanswered == "" {
+		cfg.Reconnect.Unanswered = unansweredLost
+	}
+	if u := cfg.Reconnect.Unanswered; u != unansweredResend && u != unansweredLost && u != unansweredKeep {
+		log.Fatalf("Invalid unanswered policy: %s. Allowed values are '%s', '%s' or '%s'.", u, unansweredResend, unansweredLost, unansweredKeep)
+	}
+	if cfg.ResultTimeout <= 0 {
+		cfg.ResultTimeout = report.Duration(defaultResultTimeout)
+	}
+	if pool.balanced() {
+		cfg.Endpoints = pool.hosts
+		cfg.LBPolicy = pool.policy
+	}
+	cfg.MaxRecvMsgSize, cfg.MaxSendMsgSize = pool.maxRecvMsgSize, pool.maxSendMsgSize
 
-	switch *mode {
-	case "unary":
-		runUnaryMode(client, *clientID, *workers, *interval, *transactions, *xFlag, *yFlag, *operationFlag, *latencyGt)
+	switch cfg.Mode {
+	case "unary", "unary-direct", "batch":
+		if cfg.ConnPolicy == "" {
+			cfg.ConnPolicy = policyRoundRobin
+		}
+		if cfg.ConnPolicy != policyRoundRobin && cfg.ConnPolicy != policyPerWorker && cfg.ConnPolicy != policyLeastOutstanding {
+			log.Fatalf("Invalid connection policy: %s. Allowed values are '%s', '%s' or '%s'.",
+				cfg.ConnPolicy, policyRoundRobin, policyPerWorker, policyLeastOutstanding)
+		}
+		switch cfg.Mode {
+		case "unary-direct":
+			return runUnaryDirectMode(pool, cfg, opts)
+		case "batch":
+			if cfg.BatchSize < 1 {
+				cfg.BatchSize = defaultBatchSize
+			}
+			return runBatchMode(pool, cfg, opts)
+		}
+		return runUnaryMode(pool, cfg, opts)
 	case "bidirectional":
-		runBidiMode(client, *clientID, *interval, *transactions, *xFlag, *yFlag, *operationFlag, *latencyGt)
+		if cfg.Streams < 1 {
+			cfg.Streams = 1
+		}
+		if cfg.StreamPolicy == "" {
+			cfg.StreamPolicy = policyRoundRobin
+		}
+		if cfg.StreamPolicy != policyRoundRobin && cfg.StreamPolicy != policyLeastOutstanding {
+			log.Fatalf("Invalid stream policy: %s. Allowed values are '%s' or '%s'.", cfg.StreamPolicy, policyRoundRobin, policyLeastOutstanding)
+		}
+		return runBidiMode(pool, cfg, opts)
 	default:
-		log.Fatalf("Unknown mode: %s", *mode)
+		log.Fatalf("Unknown mode: %s", cfg.Mode)
 	}
+	return nil
 }
 
-// runUnaryMode sets up a PerformCalculationFrom stream to receive responses,
-// spawns worker goroutines to call PerformCalculationTo, and tracks TPS.
-func runUnaryMode(client pb.CalculatorServiceClient, clientID string, workers, interval, totalTransactions, x, y int, operation string, latencyThreshold int) {
-	log.Printf("Running in unary mode with client-id=%s, workers=%d, interval=%dms, total transactions=%d",
-		clientID, workers, interval, totalTransactions)
-
-	// Create a new tracker.
-	tracker := tracking.NewTracker()
-	tracker.Start()
-
-	// Create a ticker to measure TPS with a done channel.
-	ticker := time.NewTicker(1 * time.Second)
-	done := make(chan struct{})
-	var tWg sync.WaitGroup
-	tWg.Add(1)
-	var totalTicks int64
-	var totalReq, totalRes int64
-	var maxReq, maxRes int64
+// runOptions holds the output settings of a run that are not part of its report.
+type runOptions struct {
+	latencyThreshold int  // only list entries slower than this (ms)
+	progress         bool // print every per-second bucket while running
+}
 
-	go func() {
-		defer tWg.Done()
-		for {
-			select {
-			case <-ticker.C:
-				req := atomic.SwapInt64(&requestCounter, 0)
-				res := atomic.SwapInt64(&responseCounter, 0)
-				totalTicks++
-				totalReq += req
-				totalRes += res
-				if req > maxReq {
-					maxReq = req
-				}
-				if res > maxRes {
-					maxRes = res
-				}
-			case <-done:
-				return
-			}
+// newMeter creates the per-second meter of a run and, with -progress, prints
+// every bucket as it closes. Open-loop runs record the target rate of the
+// load profile, which starts at start, in every bucket and close buckets on
+// whole seconds of the profile.
+func newMeter(tracker *tracking.Tracker, profile loadgen.Profile, start time.Time, opts runOptions) *tracking.Meter {
+	meter := tracking.NewMeter(tracker)
+	if profile != nil {
+		meter.Origin = start
+		meter.TargetRate = func(from, to time.Time) float64 {
+			return loadgen.AverageRate(profile, from.Sub(start), to.Sub(start))
 		}
-	}()
+	}
+	if opts.progress {
+		meter.OnSecond = func(s tracking.Second) {
+			log.Print(s.String())
+		}
+	}
+	return meter
+}
 
-	// Set up the response stream (attach JWT token as well).
-	jwtToken := getJWTToken(clientID)
-	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken)
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	respStream, err := client.PerformCalculationFrom(ctx, &emptypb.Empty{})
-	if err != nil {
-		log.Fatalf("Failed to open PerformCalculationFrom stream: %v", err)
+// buildReport collects the results of a finished run.
+func buildReport(cfg report.RunConfig, tracker *tracking.Tracker, meter *tracking.Meter) *report.Report {
+	sent, received := tracker.Counts()
+	rep := &report.Report{
+		Config:          cfg,
+		StartedAt:       tracker.StartTime(),
+		DurationSeconds: tracker.Duration().Seconds(),
+		Sent:            sent,
+		Received:        received,
+		Duplicates:      tracker.Duplicates(),
+		Lost:            tracker.Lost(),
+		Phases:          tracker.PhaseCounts(),
+		Errors:          meter.Errors(),
+		TPS:             meter.TPS(),
+		Latency:         tracker.LatencySummary(),
+		Series:          meter.Series(),
+		Breakdown:       tracker.LabelSummary(),
+		Reconnects:      tracker.Reconnects(),
+	}
+	for _, rc := range rep.Reconnects {
+		rep.DowntimeSeconds += rc.DowntimeMs / 1000
+	}
+	if cfg.Profile != "" || (cfg.Rate > 0 && cfg.SLO != (report.SLO{})) {
+		rep.Saturation = report.Saturate(rep.Series, cfg.SLO)
 	}
+	return rep
+}
 
-	// Goroutine to process responses.
-	go func() {
-		for {
-			resp, err := respStream.Recv()
-			if err != nil {
-				log.Printf("Response stream closed: %v", err)
-				return
-			}
+// printSummary logs the end-of-run summary and the slow entries.
+func printSummary(rep *report.Report, tracker *tracking.Tracker, opts runOptions) {
+	log.Printf("==== SUMMARY ====")
+	log.Printf("Duration: %0.2fs", rep.DurationSeconds)
+	log.Printf(tracker.SentReceivedSummary())
+	log.Printf("Average Request TPS: %.2f, Max Request TPS: %d", rep.TPS.AverageRequest, rep.TPS.MaxRequest)
+	log.Printf("Average Response TPS: %.2f, Max Response TPS: %d", rep.TPS.AverageResponse, rep.TPS.MaxResponse)
+	log.Printf("Errors: %d %v", rep.TotalErrors(), rep.Errors)
+	if rep.Duplicates > 0 {
+		log.Printf("Duplicates: %d", rep.Duplicates)
+	}
+	if len(rep.Reconnects) > 0 || rep.Lost > 0 {
+		log.Printf("Reconnects: %d, Downtime: %.2fs, Lost: %d", len(rep.Reconnects), rep.DowntimeSeconds, rep.Lost)
+	}
+	log.Printf(tracker.SendLagSummary())
+	log.Printf(rep.Latency.String())
+	if rep.Saturation != nil {
+		log.Print(rep.Saturation.String())
+	}
+	if len(rep.Breakdown) > 0 {
+		log.Printf("Breakdown:      %10s %10s %10s %10s %10s %10s", "Sent", "Received", "Avg (ms)", "p50 (ms)", "p99 (ms)", "Max (ms)")
+		for _, b := range rep.Breakdown {
+			log.Printf("  %-12s %10d %10d %10.3f %10.3f %10.3f %10.3f",
+				b.Label, b.Sent, b.Received, b.Latency.AverageLatency, b.Latency.MedianLatency, b.Latency.P99Latency, b.Latency.MaxLatency)
+		}
+	}
+	log.Printf("Tracking summary (only entries with latency > %dms, first %d):", opts.latencyThreshold, slowEntryLimit)
+	for _, entry := range tracker.SlowEntries() {
+		log.Printf("ID=%d, Sent=%s, Response=%s, Received=%t, Latency=%s, Corrected=%s",
+			entry.Sent.ID, entry.Sent.String(), entry.Response.String(), entry.Received, entry.Latency, entry.CorrectedLatency)
+	}
+}
 
-			payload, err := messagesigning.Verify(resp.GetPayload())
-			if err != nil {
-				log.Printf("Failed to verify response: %v", err)
-				continue
-			}
+// loadProfile returns the open-loop load profile of the run: the parsed
+// cfg.Profile, a constant cfg.Rate, or nil for a closed-loop run.
+func loadProfile(cfg report.RunConfig) loadgen.Profile {
+	if cfg.Profile != "" {
+		profile, err := loadgen.ParseProfile(cfg.Profile)
+		if err != nil {
+			log.Fatalf("Invalid load profile: %v", err)
+		}
+		return profile
+	}
+	if cfg.Rate > 0 {
+		return loadgen.Constant{Rate: cfg.Rate}
+	}
+	return nil
+}
 
-			respCalc, err := calculation.Read(payload)
-			if err != nil {
-				log.Printf("Failed to read response: %v", err)
-				continue
-			}
-			tracker.RecordResponse(*respCalc)
-			if entry, ok := tracker.GetEntry(respCalc.ID); ok {
-				if *verbose {
-					log.Printf("Received response for ID=%d, Latency=%dms, Response: %s", respCalc.ID, entry.LatencyMs, respCalc.String())
-				}
-				atomic.AddInt64(&responseCounter, 1)
-			} else {
-				log.Printf("Received response for unknown ID=%d", respCalc.ID)
-			}
+// scheduleTransactions returns the channel transactions are taken from.
+// Without a profile slots are handed out as fast as the senders take them
+// (closed-loop, paced by the sender's sleep); otherwise slots are released by
+// an open-loop Pacer following the profile from start. The schedule ends after
+// cfg.Transactions sends or after cfg.Duration, whichever comes first.
+func scheduleTransactions(cfg report.RunConfig, profile loadgen.Profile, start time.Time, done <-chan struct{}) <-chan loadgen.Slot {
+	var until time.Time
+	if cfg.Duration > 0 {
+		until = start.Add(time.Duration(cfg.Duration))
+	}
+	if profile != nil {
+		return loadgen.NewProfilePacer(profile).Run(start, cfg.Transactions, until, done)
+	}
+	return loadgen.Sequence(cfg.Transactions, until, done)
+}
+
+// startPhases moves the tracker and meter through the run's phases: warm-up
+// for cfg.Warmup, then measure, then cool-down for the last cfg.Cooldown of
+// cfg.Duration. The returned function cancels the pending phase changes.
+func startPhases(cfg report.RunConfig, tracker *tracking.Tracker, meter *tracking.Meter) (stop func()) {
+	setPhase := func(p tracking.Phase) {
+		tracker.SetPhase(p)
+		meter.SetPhase(p)
+	}
+	var timers []*time.Timer
+	if cfg.Warmup > 0 {
+		setPhase(tracking.PhaseWarmup)
+		timers = append(timers, time.AfterFunc(time.Duration(cfg.Warmup), func() {
+			setPhase(tracking.PhaseMeasure)
+			log.Printf("Warm-up of %s done, measuring from now on", time.Duration(cfg.Warmup))
+		}))
+	}
+	if cfg.Cooldown > 0 {
+		timers = append(timers, time.AfterFunc(time.Duration(cfg.Duration-cfg.Cooldown), func() {
+			setPhase(tracking.PhaseCooldown)
+			log.Printf("Measurement done, cooling down for %s", time.Duration(cfg.Cooldown))
+		}))
+	}
+	return func() {
+		for _, t := range timers {
+			t.Stop()
 		}
-	}()
+	}
+}
 
-	// Create a channel to act as a task queue.
-	tasks := make(chan int, totalTransactions)
-	for i := 0; i < totalTransactions; i++ {
-		tasks <- i
+// operationMix parses the run's operation or operation mix.
+func operationMix(cfg report.RunConfig) *loadgen.Mix {
+	mix, err := loadgen.ParseMix(cfg.Operation)
+	if err != nil {
+		log.Fatalf("Invalid operation: %v", err)
 	}
-	close(tasks)
+	return mix
+}
+
+// runState is what every mode sets up before its first send: the load
+// profile, the tracker, the per-second meter, the phases and the operation
+// mix of the run.
+type runState struct {
+	cfg        report.RunConfig
+	opts       runOptions
+	profile    loadgen.Profile
+	mix        *loadgen.Mix
+	start      time.Time
+	tracker    *tracking.Tracker
+	meter      *tracking.Meter
+	stopPhases func()
+}
+
+// startRun starts the tracker, the meter and the phases of the run.
+func startRun(cfg report.RunConfig, opts runOptions) *runState {
+	run := &runState{cfg: cfg, opts: opts, profile: loadProfile(cfg), mix: operationMix(cfg)}
+
+	// Create a new tracker.
+	run.tracker = tracking.NewTracker()
+	run.tracker.KeepSlowerThan(time.Duration(opts.latencyThreshold)*time.Millisecond, slowEntryLimit)
+	run.tracker.Start()
+
+	// Create a meter to measure TPS per second.
+	run.start = time.Now()
+	run.meter = newMeter(run.tracker, run.profile, run.start, opts)
+	run.meter.Start()
+	run.stopPhases = startPhases(cfg, run.tracker, run.meter)
+	return run
+}
+
+// work spawns cfg.Workers goroutines that each call work with their worker ID
+// and the scheduled transactions, and returns once they are all done.
+func (run *runState) work(work func(workerID int, tasks <-chan loadgen.Slot)) {
+	stopSchedule := make(chan struct{})
+	defer close(stopSchedule)
+	tasks := scheduleTransactions(run.cfg, run.profile, run.start, stopSchedule)
 
-	// Spawn worker goroutines to process tasks.
 	var wg sync.WaitGroup
-	for i := 0; i < workers; i++ {
+	for i := 0; i < run.cfg.Workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for task := range tasks {
-				// Use the task as the transaction index.
-				calc := calculation.Calculation{
-					ID:        int32(task), // Unique transaction ID.
-					X:         x,
-					Y:         y,
-					Operation: operation,
+			work(workerID, tasks)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// calc returns the calculation of transaction seq, which is also its ID.
+func (run *runState) calc(seq int) calculation.Calculation {
+	return calculation.Calculation{
+		ID:            int32(seq),
+		X:             run.cfg.X,
+		Y:             run.cfg.Y,
+		Operation:     run.mix.Pick(seq),
+		Padding:       runPadding,
+		ResponseBytes: run.cfg.ResponseBytes,
+	}
+}
+
+// pace sleeps the interval between two sends of a closed-loop run.
+func (run *runState) pace() {
+	if run.profile == nil {
+		time.Sleep(time.Duration(run.cfg.IntervalMs) * time.Millisecond)
+	}
+}
+
+// stopMeter stops the phases and the meter once all transactions are sent.
+func (run *runState) stopMeter() {
+	run.stopPhases()
+	run.meter.Stop()
+}
+
+// finish stops the tracker and reports the run.
+func (run *runState) finish() *report.Report {
+	run.tracker.Stop()
+	rep := buildReport(run.cfg, run.tracker, run.meter)
+	printSummary(rep, run.tracker, run.opts)
+	return rep
+}
+
+// signCalc encodes calc with the run's codec and signs it.
+func signCalc(calc *calculation.Calculation) (*pb.CalcMessage, error) {
+	message, err := payloadCodec.Marshal(calc)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+	signedMessage, err := messagesigning.Sign(message)
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+	return &pb.CalcMessage{Payload: signedMessage}, nil
+}
+
+// signedCalc is signCalc for a run, which cannot go on without it.
+func signedCalc(calc *calculation.Calculation) *pb.CalcMessage {
+	msg, err := signCalc(calc)
+	if err != nil {
+		log.Fatalf("Failed to encode message: %v", err)
+	}
+	return msg
+}
+
+// runUnaryMode sets up a PerformCalculationFrom stream to receive responses,
+// spawns worker goroutines to call PerformCalculationTo, and tracks TPS.
+func runUnaryMode(pool *connPool, cfg report.RunConfig, opts runOptions) *report.Report {
+	clientID := cfg.ClientID
+	run := startRun(cfg, opts)
+	if run.profile != nil {
+		log.Printf("Running in unary mode with client-id=%s, workers=%d, conns=%d (%s), load=%s (open-loop), total transactions=%d",
+			clientID, cfg.Workers, cfg.Conns, cfg.ConnPolicy, run.profile, cfg.Transactions)
+	} else {
+		log.Printf("Running in unary mode with client-id=%s, workers=%d, conns=%d (%s), interval=%dms, total transactions=%d",
+			clientID, cfg.Workers, cfg.Conns, cfg.ConnPolicy, cfg.IntervalMs, cfg.Transactions)
+	}
+	conns := newConnPicker(pool.clients(cfg.Conns), cfg.ConnPolicy)
+	tracker, meter := run.tracker, run.meter
+
+	// Set up the response stream (attach JWT token as well).
+	jwtToken := getJWTToken(clientID)
+	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken, calculation.CodecHeader, payloadCodec.Name())
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	defer cancel()
+	rc := &reconnector{policy: cfg.Reconnect, tracker: tracker, meter: meter, done: ctx.Done()}
+	accepted := newAcceptedCalls()
+	// A server keeps one subscription per client, so with a single endpoint it
+	// lives on the first connection. With several endpoints every backend gets
+	// its own, as a call is answered by the backend that received it.
+	for _, backend := range pool.backendClients() {
+		respStream, err := subscribe(ctx, backend)
+		if err != nil {
+			log.Fatalf("Failed to open PerformCalculationFrom stream: %v", err)
+		}
+		go subscribeUnary(ctx, backend, respStream, pool, clientID, cfg.Acks, accepted, rc)
+	}
+	go expireResults(ctx, time.Duration(cfg.ResultTimeout), accepted, tracker)
+
+	run.work(func(workerID int, tasks <-chan loadgen.Slot) {
+		for task := range tasks {
+			calc := run.calc(task.Seq)
+			conn := conns.pick(workerID)
+			tracker.AddLabeled(calc, task.Intended, conns.labels[conn]...)
+			msg := signedCalc(&calc)
+			// Generate (or re-use) JWT token as per mode.
+			jwtToken := getJWTToken(clientID)
+			reqMd := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken, calculation.CodecHeader, payloadCodec.Name())
+			reqCtx := metadata.NewOutgoingContext(context.Background(), reqMd)
+			// The balancer decides which backend answers the call, so the
+			// backend label of the send is only known afterwards.
+			var callPeer peer.Peer
+			var trailer metadata.MD
+			_, err := conns.clients[conn].PerformCalculationTo(reqCtx, msg, grpc.Peer(&callPeer), grpc.Trailer(&trailer))
+			conns.done(conn)
+			if err == nil {
+				var backend []string
+				if pool.balanced() && callPeer.Addr != nil {
+					backend = []string{"backend=" + callPeer.Addr.String()}
+					tracker.CountSent(backend[0])
 				}
-				tracker.AddSent(calc)
-				message, err := calc.Bytes()
-				if err != nil {
-					log.Fatalf("Failed to serialize message: %v", err)
+				// The result may have arrived before the call returned.
+				if !tracker.Answered(calc.ID) {
+					accepted.add(strings.Join(backend, " "), calc.ID)
 				}
-				signedMessage, err := messagesigning.Sign(message)
-				if err != nil {
-					log.Fatalf("Failed to sign message: %v", err)
+			}
+			// The server reports the results its subscriber buffer dropped
+			// because of this call; they will never arrive.
+			if vals := trailer.Get("dropped"); len(vals) > 0 {
+				dropped, _ := strconv.Atoi(vals[0])
+				for ; dropped > 0; dropped-- {
+					meter.AddError("dropped")
 				}
-				msg := &pb.CalcMessage{
-					Payload: signedMessage,
+			}
+			if status.Code(err) == codes.ResourceExhausted && !messageTooLarge(err) {
+				meter.AddError("rejected")
+				if *verbose {
+					log.Printf("Worker %d: transaction %d rejected: %v", workerID, task.Seq, err)
 				}
-				// Generate (or re-use) JWT token as per mode.
-				jwtToken := getJWTToken(clientID)
-				reqMd := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken)
-				reqCtx := metadata.NewOutgoingContext(context.Background(), reqMd)
-				_, err = client.PerformCalculationTo(reqCtx, msg)
-				if err != nil {
-					if *verbose {
-						log.Printf("Worker %d: error sending transaction %d: %v", workerID, task, err)
-					}
-				} else {
-					atomic.AddInt64(&requestCounter, 1)
-					if *verbose {
-						log.Printf("Worker %d: sent transaction %d", workerID, task)
-					}
+			} else if err != nil {
+				meter.AddError(errorKind(err, "send"))
+				if *verbose {
+					log.Printf("Worker %d: error sending transaction %d: %v", workerID, task.Seq, err)
+				}
+			} else {
+				meter.AddRequest()
+				if *verbose {
+					log.Printf("Worker %d: sent transaction %d", workerID, task.Seq)
 				}
-				time.Sleep(time.Duration(interval) * time.Millisecond)
 			}
-		}(i)
-	}
-	wg.Wait()
+			// A failed call is not answered over the subscription.
+			if err != nil {
+				tracker.MarkLost(calc.ID)
+			}
+			run.pace()
+		}
+	})
 
-	// All transactions sent—stop the ticker and signal the ticker goroutine to exit.
-	ticker.Stop()
-	close(done)
-	tWg.Wait()
+	// All transactions sent—stop the meter.
+	run.stopMeter()
 
 	// Optionally, wait a bit for any pending responses.
 	log.Printf("All workers done. Waiting for pending responses...")
 	time.Sleep(2 * time.Second)
-	tracker.Stop()
+	return run.finish()
+}
 
-	// Compute summary metrics.
-	avgReq := float64(totalReq) / float64(totalTicks)
-	avgRes := float64(totalRes) / float64(totalTicks)
-	log.Printf("==== SUMMARY ====")
-	log.Printf("Duration: %0.2fs", tracker.Duration().Seconds())
-	log.Printf(tracker.SentReceivedSummary())
-	log.Printf("Average Request TPS: %.2f, Max Request TPS: %d", avgReq, maxReq)
-	log.Printf("Average Response TPS: %.2f, Max Response TPS: %d", avgRes, maxRes)
+// defaultResultTimeout is the result timeout of a run that does not set it.
+const defaultResultTimeout = 30 * time.Second
 
-	log.Printf(tracker.LatencySummary().String())
-	log.Printf("Tracking summary (only entries with latency > %dms):", latencyThreshold)
-	// Print tracking summary for entries with latency greater than the threshold.
-	for id, entry := range tracker.Data() {
-		if entry.LatencyMs > int64(latencyThreshold) {
-			log.Printf("ID=%d, Sent=%s, Response=%s, Received=%t, Latency=%dms",
-				id, entry.Sent.String(), entry.Response.String(), entry.Received, entry.LatencyMs)
+// expireResults gives up on the results that are more than timeout late
+// every second until ctx is done. A result the server dropped from the
+// subscriber buffer never arrives, and the "dropped" trailer only says how
+// many there were, so without this it would stay in flight for the rest of
+// the run. The calls accepted that long ago are forgotten as well.
+func expireResults(ctx context.Context, timeout time.Duration, accepted *acceptedCalls, tracker *tracking.Tracker) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := tracker.ExpireSentBefore(now.Add(-timeout)); n > 0 && *verbose {
+				log.Printf("Gave up on %d results after %s", n, timeout)
+			}
+			accepted.expire(now.Add(-timeout))
 		}
 	}
 }
 
-// runBidiMode establishes a PerformCalculationBi stream for bidirectional messaging,
-// and uses similar TPS tracking as in unary mode.
-func runBidiMode(client pb.CalculatorServiceClient, clientID string, interval, transactions, x, y int, operation string, latencyThreshold int) {
-	log.Printf("Running in bidirectional mode with client-id=%s, interval=%dms, total transactions=%d", clientID, interval, transactions)
+// subscribe opens a PerformCalculationFrom subscription on backend and waits
+// for the server to confirm it with the response header, so that no result
+// is sent before the subscription is in place.
+func subscribe(ctx context.Context, backend pb.CalculatorServiceClient) (pb.CalculatorService_PerformCalculationFromClient, error) {
+	respStream, err := backend.PerformCalculationFrom(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := respStream.Header(); err != nil {
+		return nil, err
+	}
+	return respStream, nil
+}
 
-	// Create a new tracker.
-	tracker := tracking.NewTracker()
-	tracker.Start()
-
-	// Create a ticker for TPS tracking with a done channel.
-	ticker := time.NewTicker(1 * time.Second)
-	done := make(chan struct{})
-	var tWg sync.WaitGroup
-	tWg.Add(1)
-	var totalTicks int64
-	var totalReq, totalRes int64
-	var maxReq, maxRes int64
+// acceptedCalls keeps, per backend label, the transactions whose
+// PerformCalculationTo call the backend accepted and whose result has not
+// arrived yet, with the time the call returned: the results a subscription on
+// that backend owes. Against a single endpoint the label is empty.
+type acceptedCalls struct {
+	mu  sync.Mutex
+	ids map[string]map[int32]time.Time
+}
 
-	go func() {
-		defer tWg.Done()
-		for {
-			select {
-			case <-ticker.C:
-				req := atomic.SwapInt64(&requestCounter, 0)
-				res := atomic.SwapInt64(&responseCounter, 0)
-				totalTicks++
-				totalReq += req
-				totalRes += res
-				if req > maxReq {
-					maxReq = req
+func newAcceptedCalls() *acceptedCalls {
+	return &acceptedCalls{ids: map[string]map[int32]time.Time{}}
+}
+
+// add records that backend accepted the call of id just now.
+func (a *acceptedCalls) add(backend string, id int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ids[backend] == nil {
+		a.ids[backend] = map[int32]time.Time{}
+	}
+	a.ids[backend][id] = time.Now()
+}
+
+// remove forgets id once it is answered or given up.
+func (a *acceptedCalls) remove(backend string, id int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.ids[backend], id)
+}
+
+// expire forgets the calls accepted before the given time.
+func (a *acceptedCalls) expire(before time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ids := range a.ids {
+		for id, at := range ids {
+			if at.Before(before) {
+				delete(ids, id)
+			}
+		}
+	}
+}
+
+// snapshot returns the IDs backend accepted before the given time and still
+// owes, sorted.
+func (a *acceptedCalls) snapshot(backend string, before time.Time) []int32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ids []int32
+	for id, at := range a.ids[backend] {
+		if at.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// subscribeUnary receives on respStream, a subscription on backend, and
+// reopens the subscription with rc whenever it breaks until ctx is done. A
+// reopened subscription passes the sequence number of the last result seen,
+// so that a server with a mailbox replays the results missed in between. The
+// transactions backend had accepted when the subscription broke are settled
+// by rc in the background, resent to backend. With acks, every result
+// received is acknowledged to backend.
+func subscribeUnary(ctx context.Context, backend pb.CalculatorServiceClient, respStream pb.CalculatorService_PerformCalculationFromClient, pool *connPool, clientID string, acks bool, accepted *acceptedCalls, rc *reconnector) {
+	tracker, meter := rc.tracker, rc.meter
+	var ack func(seq uint64)
+	if acks {
+		ack = startAcker(ctx, backend, meter)
+	}
+	labels := pool.backendLabel(respStream.Context())
+	key := strings.Join(labels, " ")
+	name := strings.Join(append([]string{"subscription"}, labels...), " ")
+	var lastSeq uint64
+	for {
+		seq, err := receiveUnary(respStream, labels, ack, accepted, tracker, meter)
+		lastSeq = max(lastSeq, seq)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Response stream closed: %v", err)
+		// A result above the max message size breaks the subscription.
+		if messageTooLarge(err) {
+			meter.AddError(errorKind(err, ""))
+		}
+		var subscribed time.Time
+		rec, ok := rc.reconnect(name, err, func() error {
+			subscribed = time.Now()
+			resumeCtx := metadata.AppendToOutgoingContext(ctx, "last-seq", strconv.FormatUint(lastSeq, 10))
+			s, err := subscribe(resumeCtx, backend)
+			if err != nil {
+				return err
+			}
+			respStream = s
+			return nil
+		})
+		if !ok {
+			return
+		}
+		log.Printf("Resubscribed after result %d", lastSeq)
+
+		// The results backend owes are those of the calls it accepted before
+		// the subscription was back, through the old one or while there was
+		// none. Calls accepted since are answered on the new subscription,
+		// and other backends answer their own.
+		owed := accepted.snapshot(key, subscribed)
+
+		// Settle in the background, so that the results of the transactions
+		// sent again, and those a mailbox replays, are read meanwhile. Under
+		// the lost policy a replayed result may arrive after its transaction
+		// was given up; keep waits for the replays instead.
+		go func(rec tracking.Reconnect) {
+			resent, lost := rc.settle(owed, func(id int32) error {
+				entry, ok := tracker.GetEntry(id)
+				if !ok {
+					return nil // answered in the meantime
 				}
-				if res > maxRes {
-					maxRes = res
+				return resendUnary(backend, clientID, entry.Sent)
+			})
+			for _, id := range owed {
+				if _, ok := tracker.GetEntry(id); !ok {
+					accepted.remove(key, id)
 				}
-			case <-done:
-				return
 			}
-		}
-	}()
+			rec.Resent, rec.Lost = len(resent), lost
+			rc.record(rec)
+		}(rec)
+	}
+}
 
-	// Establish bidirectional stream (attach JWT token as well).
-	jwtToken := getJWTToken(clientID)
-	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken)
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-	stream, err := client.PerformCalculationBi(ctx)
+// resendUnary sends calc again in a PerformCalculationTo call to backend,
+// with the padding the tracker does not keep.
+func resendUnary(backend pb.CalculatorServiceClient, clientID string, calc calculation.Calculation) error {
+	calc.Padding = runPadding
+	msg, err := signCalc(&calc)
 	if err != nil {
-		log.Fatalf("Failed to establish PerformCalculationBi stream: %v", err)
+		return err
 	}
+	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+getJWTToken(clientID), calculation.CodecHeader, payloadCodec.Name())
+	_, err = backend.PerformCalculationTo(metadata.NewOutgoingContext(context.Background(), md), msg)
+	return err
+}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	// Goroutine for receiving messages.
+// ackBatch is the largest number of results acknowledged in one Ack call.
+const ackBatch = 256
+
+// startAcker starts acknowledging results to backend until ctx is done and
+// returns the function to queue the acknowledgement of a result with. Results
+// queued while an Ack call is in flight are acknowledged together.
+func startAcker(ctx context.Context, backend pb.CalculatorServiceClient, meter *tracking.Meter) func(seq uint64) {
+	queue := make(chan uint64, ackBatch)
 	go func() {
-		defer wg.Done()
 		for {
-			resp, err := stream.Recv()
-			if err != nil {
-				log.Printf("Bidirectional receive error: %v", err)
+			var seq uint64
+			select {
+			case <-ctx.Done():
 				return
+			case seq = <-queue:
 			}
-
-			payload, err := messagesigning.Verify(resp.GetPayload())
-			if err != nil {
-				log.Printf("Failed to verify response: %v", err)
-				continue
-			}
-
-			respCalc, err := calculation.Read(payload)
-			if err != nil {
-				log.Printf("Error reading response: %v", err)
-				continue
+			seqs := []uint64{seq}
+		batch:
+			for len(seqs) < ackBatch {
+				select {
+				case seq = <-queue:
+					seqs = append(seqs, seq)
+				default:
+					break batch
+				}
 			}
-			tracker.RecordResponse(*respCalc)
-			if entry, ok := tracker.GetEntry(respCalc.ID); ok {
+			if _, err := backend.Ack(ctx, &pb.AckRequest{Seqs: seqs}); err != nil && ctx.Err() == nil {
+				meter.AddError("ack")
 				if *verbose {
-					log.Printf("Received response for ID=%d, Latency=%dms, Response: %s", respCalc.ID, entry.LatencyMs, respCalc.String())
+					log.Printf("Failed to acknowledge %d results: %v", len(seqs), err)
 				}
-				atomic.AddInt64(&responseCounter, 1)
-			} else {
-				log.Printf("Received response for unknown ID=%d", respCalc.ID)
 			}
 		}
 	}()
-
-	// Send transactions on the bidirectional stream.
-	for i := 0; i < transactions; i++ {
-		calc := calculation.Calculation{
-			ID:        int32(i),
-			X:         x,
-			Y:         y,
-			Operation: operation,
+	return func(seq uint64) {
+		select {
+		case queue <- seq:
+		case <-ctx.Done():
 		}
-		tracker.AddSent(calc)
-		message, err := calc.Bytes()
+	}
+}
+
+// receiveUnary reads the responses of one PerformCalculationFrom subscription
+// until it ends, records them under labels, removes them from accepted and
+// returns the highest sequence number seen. Every result read is
+// acknowledged with ack, if not nil.
+func receiveUnary(respStream pb.CalculatorService_PerformCalculationFromClient, labels []string, ack func(seq uint64), accepted *acceptedCalls, tracker *tracking.Tracker, meter *tracking.Meter) (uint64, error) {
+	var lastSeq uint64
+	for {
+		resp, err := respStream.Recv()
 		if err != nil {
-			log.Fatalf("Failed to serialize message: %v", err)
+			return lastSeq, err
 		}
+		lastSeq = max(lastSeq, resp.GetSeq())
 
-		signedMessage, err := messagesigning.Sign(message)
+		payload, err := messagesigning.Verify(resp.GetPayload())
 		if err != nil {
-			log.Fatalf("Failed to sign message: %v", err)
+			log.Printf("Failed to verify response: %v", err)
+			meter.AddError("verify")
+			continue
 		}
 
-		msg := &pb.CalcMessage{
-			Payload: signedMessage,
+		respCalc, err := payloadCodec.Unmarshal(payload)
+		if err != nil {
+			log.Printf("Failed to read response: %v", err)
+			meter.AddError("decode")
+			continue
 		}
-
-		if err := stream.Send(msg); err != nil {
-			log.Printf("Error sending message %d: %v", i, err)
-			break
+		if entry, ok := tracker.RecordResponse(*respCalc, labels...); ok {
+			accepted.remove(strings.Join(labels, " "), respCalc.ID)
+			if *verbose {
+				log.Printf("Received response for ID=%d, Latency=%s, Response: %s", respCalc.ID, entry.Latency, respCalc.String())
+			}
+			meter.AddResponse()
+		} else if tracker.Answered(respCalc.ID) {
+			if *verbose {
+				log.Printf("Received duplicate response for ID=%d", respCalc.ID)
+			}
+		} else {
+			log.Printf("Received response for unknown ID=%d", respCalc.ID)
+			meter.AddError("unknown_id")
 		}
-		atomic.AddInt64(&requestCounter, 1)
-		if *verbose {
-			log.Printf("Sent bidirectional message %d", i)
+		if ack != nil && resp.GetSeq() != 0 {
+			ack(resp.GetSeq())
 		}
-		time.Sleep(time.Duration(interval) * time.Millisecond)
 	}
+}
 
-	// All transactions sent—stop the ticker and signal the ticker goroutine to exit.
-	ticker.Stop()
-	close(done)
-	tWg.Wait()
+// runUnaryDirectMode spawns worker goroutines that call PerformCalculation,
+// which returns the result as the response, and tracks TPS the same way as
+// unary mode. No subscription is needed.
+func runUnaryDirectMode(pool *connPool, cfg report.RunConfig, opts runOptions) *report.Report {
+	clientID := cfg.ClientID
+	run := startRun(cfg, opts)
+	if run.profile != nil {
+		log.Printf("Running in unary-direct mode with client-id=%s, workers=%d, conns=%d (%s), load=%s (open-loop), total transactions=%d",
+			clientID, cfg.Workers, cfg.Conns, cfg.ConnPolicy, run.profile, cfg.Transactions)
+	} else {
+		log.Printf("Running in unary-direct mode with client-id=%s, workers=%d, conns=%d (%s), interval=%dms, total transactions=%d",
+			clientID, cfg.Workers, cfg.Conns, cfg.ConnPolicy, cfg.IntervalMs, cfg.Transactions)
+	}
+	conns := newConnPicker(pool.clients(cfg.Conns), cfg.ConnPolicy)
+	tracker, meter := run.tracker, run.meter
+
+	run.work(func(workerID int, tasks <-chan loadgen.Slot) {
+		for task := range tasks {
+			calc := run.calc(task.Seq)
+			conn := conns.pick(workerID)
+			tracker.AddLabeled(calc, task.Intended, conns.labels[conn]...)
+			msg := signedCalc(&calc)
+			// Generate (or re-use) JWT token as per mode.
+			jwtToken := getJWTToken(clientID)
+			reqMd := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken, calculation.CodecHeader, payloadCodec.Name())
+			reqCtx := metadata.NewOutgoingContext(context.Background(), reqMd)
+			var callPeer peer.Peer
+			resp, err := conns.clients[conn].PerformCalculation(reqCtx, msg, grpc.Peer(&callPeer))
+			conns.done(conn)
+			if err != nil {
+				meter.AddError(errorKind(err, "send"))
+				tracker.MarkLost(calc.ID)
+				if *verbose {
+					log.Printf("Worker %d: error sending transaction %d: %v", workerID, task.Seq, err)
+				}
+			} else {
+				meter.AddRequest()
+				// The backend that answered is known once the call is done.
+				var labels []string
+				if pool.balanced() && callPeer.Addr != nil {
+					labels = []string{"backend=" + callPeer.Addr.String()}
+					tracker.CountSent(labels[0])
+				}
+				recordDirect(resp, labels, tracker, meter)
+			}
+			run.pace()
+		}
+	})
+
+	// Every response arrived with its call—stop the meter.
+	run.stopMeter()
+	return run.finish()
+}
 
-	if err := stream.CloseSend(); err != nil {
-		log.Printf("Error closing bidirectional stream: %v", err)
+// recordDirect verifies the response of a PerformCalculation call and
+// records it under labels.
+func recordDirect(resp *pb.CalcMessage, labels []string, tracker *tracking.Tracker, meter *tracking.Meter) {
+	payload, err := messagesigning.Verify(resp.GetPayload())
+	if err != nil {
+		log.Printf("Failed to verify response: %v", err)
+		meter.AddError("verify")
+		return
 	}
-	wg.Wait()
+	respCalc, err := payloadCodec.Unmarshal(payload)
+	if err != nil {
+		log.Printf("Failed to read response: %v", err)
+		meter.AddError("decode")
+		return
+	}
+	entry, ok := tracker.RecordResponse(*respCalc, labels...)
+	if !ok {
+		log.Printf("Received response for unknown ID=%d", respCalc.ID)
+		meter.AddError("unknown_id")
+		return
+	}
+	if *verbose {
+		log.Printf("Received response for ID=%d, Latency=%s, Response: %s", respCalc.ID, entry.Latency, respCalc.String())
+	}
+	meter.AddResponse()
+}
 
-	log.Printf("All transactions sent. Waiting for pending responses...")
-	time.Sleep(2 * time.Second)
-	tracker.Stop()
+// runBidiMode establishes cfg.Streams PerformCalculationBi streams over
+// cfg.Conns connections for bidirectional messaging, and uses similar TPS
+// tracking as in unary mode. Workers sign messages and send each one on the
+// stream picked by cfg.StreamPolicy.
+func runBidiMode(pool *connPool, cfg report.RunConfig, opts runOptions) *report.Report {
+	clientID := cfg.ClientID
+	run := startRun(cfg, opts)
+	if run.profile != nil {
+		log.Printf("Running in bidirectional mode with client-id=%s, workers=%d, streams=%d (%s) over %d connection(s), load=%s (open-loop), total transactions=%d",
+			clientID, cfg.Workers, cfg.Streams, cfg.StreamPolicy, cfg.Conns, run.profile, cfg.Transactions)
+	} else {
+		log.Printf("Running in bidirectional mode with client-id=%s, workers=%d, streams=%d (%s) over %d connection(s), interval=%dms, total transactions=%d",
+			clientID, cfg.Workers, cfg.Streams, cfg.StreamPolicy, cfg.Conns, cfg.IntervalMs, cfg.Transactions)
+	}
+	tracker, meter := run.tracker, run.meter
 
-	// Compute summary metrics.
-	avgReq := float64(totalReq) / float64(totalTicks)
-	avgRes := float64(totalRes) / float64(totalTicks)
-	log.Printf("==== SUMMARY ====")
-	log.Printf("Duration: %0.2fs", tracker.Duration().Seconds())
-	log.Printf(tracker.SentReceivedSummary())
-	log.Printf("Average Request TPS: %.2f, Max Request TPS: %d", avgReq, maxReq)
-	log.Printf("Average Response TPS: %.2f, Max Response TPS: %d", avgRes, maxRes)
-	log.Printf(tracker.LatencySummary().String())
-	log.Printf("Tracking summary (only entries with latency > %dms):", latencyThreshold)
-	for id, entry := range tracker.Data() {
-		if entry.LatencyMs > int64(latencyThreshold) {
-			log.Printf("ID=%d, Sent=%s, Response=%s, Received=%t, Latency=%dms",
-				id, entry.Sent.String(), entry.Response.String(), entry.Received, entry.LatencyMs)
-		}
+	// Establish the bidirectional streams (attach JWT token as well).
+	jwtToken := getJWTToken(clientID)
+	md := metadata.Pairs("clientid", clientID, "authorization", "Bearer "+jwtToken, calculation.CodecHeader, payloadCodec.Name())
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	streams := openStreams(ctx, pool, cfg.Conns, cfg.Streams, cfg.StreamPolicy)
+
+	// One goroutine per stream receives its responses, and reopens the stream
+	// if it breaks before all transactions are sent.
+	sending := make(chan struct{})
+	rc := &reconnector{policy: cfg.Reconnect, tracker: tracker, meter: meter, done: sending}
+	var recvWG sync.WaitGroup
+	for _, s := range streams.streams {
+		recvWG.Add(1)
+		go func(s *bidiStream) {
+			defer recvWG.Done()
+			s.receive(cfg.Acks, rc)
+		}(s)
 	}
+
+	// Send transactions on the bidirectional streams.
+	run.work(func(workerID int, slots <-chan loadgen.Slot) {
+		for slot := range slots {
+			i := slot.Seq
+			s := streams.pick()
+			if s == nil {
+				// Every stream is being reopened; the transaction is skipped.
+				meter.AddError("unavailable")
+				if *verbose {
+					log.Printf("Worker %d: no stream open, skipping transaction %d", workerID, i)
+				}
+				run.pace()
+				continue
+			}
+			calc := run.calc(i)
+			tracker.AddLabeled(calc, slot.Intended, s.currentLabels()...)
+			if err := s.send(calc.ID, signedCalc(&calc)); err != nil {
+				log.Printf("Error sending message %d: %v", i, err)
+				meter.AddError(errorKind(err, "send"))
+				tracker.MarkLost(calc.ID)
+				continue
+			}
+			meter.AddRequest()
+			if *verbose {
+				log.Printf("Worker %d: sent bidirectional message %d", workerID, i)
+			}
+			run.pace()
+		}
+	})
+	close(sending)
+
+	// All transactions sent—stop the meter.
+	run.stopMeter()
+
+	// The server answers the messages of a stream in order, so its end
+	// after the half-close follows the last pending response.
+	log.Printf("All transactions sent. Waiting for pending responses...")
+	streams.closeSend()
+	recvWG.Wait()
+	return run.finish()
 }
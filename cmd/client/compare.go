@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"grpc-benchmark-study/internal/compare"
+	"grpc-benchmark-study/internal/report"
+)
+
+// runCompareCommand implements "client compare [flags] <baseline.json>
+// <candidate.json>". It prints the deltas between two saved run reports and
+// exits with status 1 if the candidate regressed past a threshold.
+func runCompareCommand(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var th compare.Thresholds
+	fs.Float64Var(&th.TPSDrop, "max-tps-drop", 5, "Maximum drop of the average response TPS in percent (0 disables)")
+	fs.Float64Var(&th.P50Increase, "max-p50-increase", 10, "Maximum increase of the p50 latency in percent (0 disables)")
+	fs.Float64Var(&th.P99Increase, "max-p99-increase", 10, "Maximum increase of the p99 latency in percent (0 disables)")
+	fs.Float64Var(&th.MaxIncrease, "max-latency-increase", 0, "Maximum increase of the max latency in percent (0 disables)")
+	fs.Float64Var(&th.ErrorRateIncrease, "max-error-rate", 1, "Maximum increase of the error rate in percentage points (0 disables)")
+	fs.Float64Var(&th.Alpha, "alpha", 0.05, "Significance level of the Mann-Whitney test over per-second samples")
+	fs.Usage = func() {
+		log.Printf("Usage: client compare [flags] <baseline.json> <candidate.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		log.Fatalf("Expected a baseline and a candidate report")
+	}
+
+	baseline, err := report.ReadJSON(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read baseline: %v", err)
+	}
+	candidate, err := report.ReadJSON(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Failed to read candidate: %v", err)
+	}
+
+	result := compare.Compare(baseline, candidate, th)
+	fmt.Printf("Baseline:  %s (%d per-second samples)\n", fs.Arg(0), len(baseline.Series))
+	fmt.Printf("Candidate: %s (%d per-second samples)\n\n", fs.Arg(1), len(candidate.Series))
+	fmt.Print(result)
+	if result.Regressed() {
+		fmt.Println("\nRegression detected")
+		os.Exit(1)
+	}
+	fmt.Println("\nNo regression")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-benchmark-study/internal/calculation"
+	"grpc-benchmark-study/internal/loadgen"
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/scenario"
+)
+
+// runMatrixCommand implements "client matrix [flags]". It runs the cartesian
+// product of the listed dimension values, repeating each cell, and prints a
+// comparison table of throughput and latency per cell.
+func runMatrixCommand(args []string) {
+	fs := flag.NewFlagSet("matrix", flag.ExitOnError)
+	hosts := addHostFlags(fs)
+	modes := fs.String("modes", "unary,bidirectional", "Comma-separated modes: unary, unary-direct, batch or bidirectional")
+	workers := fs.String("workers", "1", "Comma-separated worker counts")
+	streams := fs.String("streams", "", "Comma-separated bidirectional stream counts")
+	conns := fs.String("conns", "", "Comma-separated connection counts")
+	batchSizes := fs.String("batch-sizes", "", "Comma-separated calculations per stream in batch mode")
+	connPolicy := fs.String("conn-policy", policyRoundRobin, "Distribution of unary calls over connections: round-robin, per-worker or least-outstanding")
+	streamPolicy := fs.String("stream-policy", policyRoundRobin, "Distribution of sends over streams: round-robin or least-outstanding")
+	acks := fs.Bool("acks", false, "Acknowledge every result")
+	unanswered := fs.String("unanswered", unansweredLost, "What to do with the transactions a broken stream left unanswered: resend, lost or keep")
+	jwtGen := fs.String("jwt-gen", "once", "Comma-separated JWT generation modes")
+	codecs := fs.String("codecs", "", "Comma-separated codecs of the calculations in signed payloads, all accepted by the server (default json)")
+	fs.String("payload", "", "Deprecated: use -codecs")
+	payloadBytes := fs.String("payload-bytes", "", "Comma-separated padding sizes in bytes of every calculation")
+	padding := fs.String("padding", calculation.PaddingRandom, "Padding content: random or compressible")
+	responseBytes := fs.Int("response-bytes", 0, "Padding size in bytes of every result (0 echoes the request's padding)")
+	operations := fs.String("operations", "ADD", "Semicolon-separated operations or weighted mixes, e.g. ADD;ADD:3,ISPRIME:1 (plain operations may also be comma-separated)")
+	xValues := fs.String("x", "3", "Comma-separated values of the X number")
+	yFlag := fs.Int("y", 1, "Value of the Y number")
+	interval := fs.Int("interval", 1000, "Interval between transactions in milliseconds")
+	rateFlag := fs.String("rate", "", "Open-loop target arrival rate, e.g. 500/s (overrides -interval)")
+	profile := fs.String("profile", "", "Open-loop load profile of every run, e.g. steps:from=100/s,step=100/s,hold=10s,count=10 (overrides -rate)")
+	sloP99 := fs.Float64("slo-p99", 0, "SLO on the per-second p99 latency in ms for the saturation report (0 disables)")
+	sloErrorRate := fs.Float64("slo-error-rate", 0, "SLO on the error rate in percent for the saturation report (0 disables)")
+	transactions := fs.Int("transactions", 10, "Number of transactions per run")
+	duration := fs.Duration("duration", 0, "Maximum duration of each run (0 for no limit)")
+	warmup := fs.Duration("warmup", 0, "Warm-up period whose samples are left out of the stats")
+	cooldown := fs.Duration("cooldown", 0, "Cool-down period at the end of each run whose samples are left out of the stats")
+	clientID := fs.String("client-id", "default-client", "Client ID")
+	repeat := fs.Int("repeat", 1, "Number of repetitions of every cell")
+	pause := fs.Duration("pause", 0, "Cool-down pause between runs")
+	table := fs.String("table", "", "Also write the comparison table (markdown) to this file")
+	out := addOutputFlags(fs)
+	fs.Parse(args)
+	deprecatedAlias(fs, "payload", "codecs")
+	if (*duration > 0 || *profile != "") && !flagSet(fs, "transactions") {
+		*transactions = 0
+	}
+
+	base := report.RunConfig{
+		ClientID:      *clientID,
+		IntervalMs:    *interval,
+		ConnPolicy:    *connPolicy,
+		StreamPolicy:  *streamPolicy,
+		Acks:          *acks,
+		Reconnect:     report.Reconnect{Unanswered: *unanswered},
+		Transactions:  *transactions,
+		Duration:      report.Duration(*duration),
+		Warmup:        report.Duration(*warmup),
+		Cooldown:      report.Duration(*cooldown),
+		Y:             *yFlag,
+		Padding:       *padding,
+		ResponseBytes: *responseBytes,
+		Profile:       *profile,
+		SLO:           report.SLO{P99Ms: *sloP99, ErrorRatePct: *sloErrorRate},
+	}
+	if *rateFlag != "" {
+		rate, err := loadgen.ParseRate(*rateFlag)
+		if err != nil {
+			log.Fatalf("Invalid rate: %v", err)
+		}
+		base.Rate = rate
+	}
+	matrix := scenario.Matrix{
+		Modes:        splitList(*modes),
+		Workers:      parseIntList("workers", *workers),
+		Streams:      parseIntList("streams", *streams),
+		Conns:        parseIntList("conns", *conns),
+		BatchSizes:   parseIntList("batch-sizes", *batchSizes),
+		JWTGen:       splitList(*jwtGen),
+		Codecs:       splitList(*codecs),
+		PayloadBytes: parseIntList("payload-bytes", *payloadBytes),
+		Operations:   splitOperations(*operations),
+		X:            parseIntList("x", *xValues),
+		Repeat:       *repeat,
+	}
+	configs := matrix.Expand(base)
+
+	setupSecurity()
+	pool := hosts.pool()
+	defer pool.Close()
+
+	suite := &report.Suite{Name: "matrix", StartedAt: time.Now()}
+	for i, cfg := range configs {
+		if i > 0 && *pause > 0 {
+			log.Printf("Pausing %s before the next run", *pause)
+			time.Sleep(*pause)
+		}
+		log.Printf("==== RUN %d/%d: %s #%d ====", i+1, len(configs), cfg.Name, cfg.Repetition)
+		suite.Runs = append(suite.Runs, executeRun(pool, cfg, out.runOptions()))
+	}
+
+	comparison := report.ComparisonTable(suite.Runs)
+	log.Printf("==== COMPARISON ====\n%s", comparison)
+	if *table != "" {
+		if err := os.WriteFile(*table, []byte(comparison), 0o644); err != nil {
+			log.Fatalf("Failed to write comparison table: %v", err)
+		}
+		log.Printf("Wrote comparison table to %s", *table)
+	}
+	writeReports(suite, out)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitOperations splits the -operations flag value into one operation or
+// weighted mix per cell. Cells are separated by semicolons, as a mix such as
+// ADD:3,ISPRIME:1 uses commas itself. A value of plain operations without any
+// weights or semicolons is split on commas, as before mixes were allowed.
+func splitOperations(s string) []string {
+	if !strings.ContainsAny(s, ";:") {
+		return splitList(s)
+	}
+	var items []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIntList parses a comma-separated list of integers for the named flag.
+func parseIntList(name, s string) []int {
+	var values []int
+	for _, item := range splitList(s) {
+		v, err := strconv.Atoi(item)
+		if err != nil {
+			log.Fatalf("Invalid value %q for -%s: %v", item, name, err)
+		}
+		values = append(values, v)
+	}
+	return values
+}
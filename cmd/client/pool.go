@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"grpc-benchmark-study/internal/lb"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// hostFlags are the flags that select the server endpoints and set up the
+// connections to them.
+type hostFlags struct {
+	hosts          *string
+	lbPolicy       *string
+	maxRecvMsgSize *int
+	maxSendMsgSize *int
+}
+
+func addHostFlags(fs *flag.FlagSet) *hostFlags {
+	return &hostFlags{
+		hosts:          fs.String("host", "localhost:50051", "Server host:port, or a comma-separated list of endpoints to balance over"),
+		lbPolicy:       fs.String("lb-policy", "round_robin", "Load balancing policy over several endpoints: "+strings.Join(lb.Policies, ", ")),
+		maxRecvMsgSize: fs.Int("max-recv-msg-size", 0, "Largest message in bytes the client receives (0 for gRPC's default of 4 MiB)"),
+		maxSendMsgSize: fs.Int("max-send-msg-size", 0, "Largest message in bytes the client sends (0 for no limit)"),
+	}
+}
+
+func (h *hostFlags) pool() *connPool {
+	p := newConnPool(*h.hosts, *h.lbPolicy)
+	p.maxRecvMsgSize, p.maxSendMsgSize = *h.maxRecvMsgSize, *h.maxSendMsgSize
+	return p
+}
+
+// connPool dials connections on demand and keeps them open for all runs of a
+// command, so a scenario or matrix reuses its connections. With more than one
+// endpoint every pooled connection balances over all of them with policy.
+type connPool struct {
+	hosts    []string
+	policy   string
+	conns    []*grpc.ClientConn
+	backends []*grpc.ClientConn // one direct connection per endpoint
+
+	// Message size limits of every connection, 0 for gRPC's defaults.
+	maxRecvMsgSize int
+	maxSendMsgSize int
+}
+
+// newConnPool creates a pool for a comma-separated list of endpoints.
+func newConnPool(hosts, policy string) *connPool {
+	p := &connPool{hosts: splitList(hosts), policy: policy}
+	if len(p.hosts) == 0 {
+		log.Fatalf("No host given")
+	}
+	if len(p.hosts) > 1 && !slices.Contains(lb.Policies, policy) {
+		log.Fatalf("Invalid load balancing policy: %s. Allowed values are %s.", policy, strings.Join(lb.Policies, ", "))
+	}
+	return p
+}
+
+// balanced reports whether the pool spreads RPCs over several endpoints.
+func (p *connPool) balanced() bool {
+	return len(p.hosts) > 1
+}
+
+// dialOptions returns the options every connection of the pool is dialed
+// with besides its credentials.
+func (p *connPool) dialOptions() []grpc.DialOption {
+	var callOpts []grpc.CallOption
+	if p.maxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(p.maxRecvMsgSize))
+	}
+	if p.maxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(p.maxSendMsgSize))
+	}
+	return []grpc.DialOption{grpc.WithDefaultCallOptions(callOpts...)}
+}
+
+// clients returns n clients, each on its own connection, dialing the
+// connections that do not exist yet.
+func (p *connPool) clients(n int) []pb.CalculatorServiceClient {
+	if n < 1 {
+		n = 1
+	}
+	for len(p.conns) < n {
+		if p.balanced() {
+			conn, err := lb.Dial(p.hosts, p.policy, append(p.dialOptions(), grpc.WithTransportCredentials(transportCredentials()))...)
+			if err != nil {
+				log.Fatalf("Failed to connect to %s: %v", strings.Join(p.hosts, ","), err)
+			}
+			p.conns = append(p.conns, conn)
+		} else {
+			p.conns = append(p.conns, dial(p.hosts[0], p.dialOptions()...))
+		}
+	}
+	clients := make([]pb.CalculatorServiceClient, n)
+	for i := range clients {
+		clients[i] = pb.NewCalculatorServiceClient(p.conns[i])
+	}
+	return clients
+}
+
+// backendClients returns one client per endpoint, each connected to that
+// endpoint only, for RPCs that must reach every backend.
+func (p *connPool) backendClients() []pb.CalculatorServiceClient {
+	if !p.balanced() {
+		return p.clients(1)
+	}
+	if p.backends == nil {
+		for _, host := range p.hosts {
+			p.backends = append(p.backends, dial(host, p.dialOptions()...))
+		}
+	}
+	clients := make([]pb.CalculatorServiceClient, len(p.backends))
+	for i, conn := range p.backends {
+		clients[i] = pb.NewCalculatorServiceClient(conn)
+	}
+	return clients
+}
+
+// Close closes every connection of the pool.
+func (p *connPool) Close() {
+	for _, conn := range append(p.conns, p.backends...) {
+		conn.Close()
+	}
+}
+
+// backendLabel returns the tracker label of the backend ctx is connected to,
+// or nothing if the pool has a single endpoint.
+func (p *connPool) backendLabel(ctx context.Context) []string {
+	if !p.balanced() {
+		return nil
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		return []string{"backend=" + pr.Addr.String()}
+	}
+	return nil
+}
+
+// Connection policies of unary mode.
+const policyPerWorker = "per-worker"
+
+// connPicker spreads the unary calls of a run over its connections, either
+// round-robin per call, pinned per worker or to the connection with the
+// fewest calls in flight.
+type connPicker struct {
+	clients  []pb.CalculatorServiceClient
+	labels   [][]string // tracker labels per connection
+	policy   string
+	next     atomic.Uint64
+	inFlight []int64
+}
+
+func newConnPicker(clients []pb.CalculatorServiceClient, policy string) *connPicker {
+	p := &connPicker{
+		clients:  clients,
+		labels:   make([][]string, len(clients)),
+		policy:   policy,
+		inFlight: make([]int64, len(clients)),
+	}
+	if len(clients) > 1 {
+		for i := range clients {
+			p.labels[i] = []string{fmt.Sprintf("conn=%d", i)}
+		}
+	}
+	return p
+}
+
+// pick returns the index of the connection worker should call next and counts
+// the call as in flight until done is called with the same index.
+func (p *connPicker) pick(worker int) int {
+	n := len(p.clients)
+	var i int
+	switch p.policy {
+	case policyPerWorker:
+		i = worker % n
+	case policyLeastOutstanding:
+		i = lb.LeastOutstanding(n, &p.next, func(c int) (int64, bool) {
+			return atomic.LoadInt64(&p.inFlight[c]), true
+		})
+	default:
+		i = int((p.next.Add(1) - 1) % uint64(n))
+	}
+	atomic.AddInt64(&p.inFlight[i], 1)
+	return i
+}
+
+// done marks a call on connection i as finished.
+func (p *connPicker) done(i int) {
+	atomic.AddInt64(&p.inFlight[i], -1)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/tracking"
+)
+
+// Policies for the transactions a broken stream left unanswered.
+const (
+	unansweredResend = "resend"
+	unansweredLost   = "lost"
+	unansweredKeep   = "keep"
+)
+
+// Reconnection settings of a run that does not set them.
+const (
+	defaultReconnectBackoff    = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 2 * time.Second
+)
+
+// reconnector reopens the broken streams of a run with exponential backoff,
+// settles the transactions they left unanswered and records every
+// reconnection in the tracker.
+type reconnector struct {
+	policy  report.Reconnect
+	tracker *tracking.Tracker
+	meter   *tracking.Meter
+	done    <-chan struct{} // closed when the run no longer reconnects
+}
+
+// delay returns the wait before reconnection attempt n, counted from 0: the
+// backoff doubled n times and capped, less up to a fifth of jitter so that
+// streams that broke together do not retry in lockstep.
+func (r *reconnector) delay(n int) time.Duration {
+	d, limit := time.Duration(r.policy.Backoff), time.Duration(r.policy.MaxBackoff)
+	for ; n > 0 && d < limit; n-- {
+		d *= 2
+	}
+	d = min(d, limit)
+	return d - rand.N(d/5+1)
+}
+
+// reconnect calls open until it succeeds, waiting the backoff before every
+// attempt, and returns the reconnection of stream after it broke with cause.
+// It gives up and returns false once the run is done.
+func (r *reconnector) reconnect(stream string, cause error, open func() error) (tracking.Reconnect, bool) {
+	rec := tracking.Reconnect{Stream: stream, At: time.Now(), Error: cause.Error()}
+	for {
+		timer := time.NewTimer(r.delay(rec.Attempts))
+		select {
+		case <-r.done:
+			timer.Stop()
+			return rec, false
+		case <-timer.C:
+		}
+		rec.Attempts++
+		err := open()
+		if err == nil {
+			rec.DowntimeMs = float64(time.Since(rec.At)) / float64(time.Millisecond)
+			return rec, true
+		}
+		log.Printf("Failed to reopen %s (attempt %d): %v", stream, rec.Attempts, err)
+	}
+}
+
+// settle applies the unanswered policy to the transactions with the given
+// IDs, which a broken stream left unanswered: they are sent again with
+// resend, given up as lost, or kept waiting. A transaction that cannot be
+// sent again is lost. It returns the IDs that were sent again and the number
+// of transactions lost.
+func (r *reconnector) settle(ids []int32, resend func(id int32) error) (resent []int32, lost int) {
+	for _, id := range ids {
+		if r.policy.Unanswered == unansweredKeep {
+			continue
+		}
+		if r.policy.Unanswered == unansweredResend {
+			err := resend(id)
+			if err == nil {
+				resent = append(resent, id)
+				continue
+			}
+			if *verbose {
+				log.Printf("Failed to resend transaction %d: %v", id, err)
+			}
+		}
+		if r.tracker.MarkLost(id) > 0 {
+			r.meter.AddError("lost")
+			lost++
+		}
+	}
+	return resent, lost
+}
+
+// record logs a completed reconnection and adds it to the run's report.
+func (r *reconnector) record(rec tracking.Reconnect) {
+	log.Printf("Reopened %s after %.0f ms and %d attempt(s): %d unanswered transactions resent, %d lost",
+		rec.Stream, rec.DowntimeMs, rec.Attempts, rec.Resent, rec.Lost)
+	r.tracker.RecordReconnect(rec)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/scenario"
+)
+
+// runScenarioCommand implements "client run-scenario [flags] <file>". It
+// executes every run of a scenario file in sequence against one server and
+// writes a single combined report.
+func runScenarioCommand(args []string) {
+	fs := flag.NewFlagSet("run-scenario", flag.ExitOnError)
+	hosts := addHostFlags(fs)
+	out := addOutputFlags(fs)
+	fs.Usage = func() {
+		log.Printf("Usage: client run-scenario [flags] <scenario.yaml|scenario.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		log.Fatalf("Expected exactly one scenario file")
+	}
+
+	// Values a run does not set fall back to the single-run flag defaults.
+	defaults := scenario.Run{
+		Mode:         "unary",
+		ClientID:     "default-client",
+		Workers:      1,
+		IntervalMs:   1000,
+		Transactions: 10,
+		Operation:    "ADD",
+		JWTGen:       "once",
+		X:            3,
+		Y:            1,
+	}
+	sc, err := scenario.Load(fs.Arg(0), defaults)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+	configs := make([]report.RunConfig, 0, len(sc.Runs))
+	for _, run := range sc.Runs {
+		cfg, err := run.Config()
+		if err != nil {
+			log.Fatalf("Invalid scenario run: %v", err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	setupSecurity()
+	pool := hosts.pool()
+	defer pool.Close()
+
+	suite := &report.Suite{Name: sc.Name, StartedAt: time.Now()}
+	for i, cfg := range configs {
+		if i > 0 && sc.Pause > 0 {
+			log.Printf("Pausing %s before the next run", sc.Pause)
+			time.Sleep(sc.Pause)
+		}
+		log.Printf("==== RUN %d/%d: %s ====", i+1, len(configs), cfg.Name)
+		suite.Runs = append(suite.Runs, executeRun(pool, cfg, out.runOptions()))
+	}
+	writeReports(suite, out)
+}
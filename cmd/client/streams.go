@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"grpc-benchmark-study/internal/lb"
+	"grpc-benchmark-study/internal/messagesigning"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// Stream distribution policies of bidirectional mode.
+const (
+	policyRoundRobin       = "round-robin"
+	policyLeastOutstanding = "least-outstanding"
+)
+
+// bidiStream is one PerformCalculationBi stream of a run. When it breaks, it
+// is opened again by its receiving goroutine.
+type bidiStream struct {
+	name        string // e.g. "stream=1", for logs and reconnect events
+	open        func() (pb.CalculatorService_PerformCalculationBiClient, []string, error)
+	sendMu      sync.Mutex // serializes Send and CloseSend, which are not safe for concurrent use
+	mu          sync.Mutex // guards the fields below; taken before sendMu, never while blocked in Send
+	stream      pb.CalculatorService_PerformCalculationBiClient
+	labels      []string                  // tracker labels of every entry sent on the stream
+	inflight    map[int32]*pb.CalcMessage // sent and not yet answered, to resend after a break
+	closed      bool                      // the run is over and the stream half-closed; written with sendMu held too
+	outstanding int64                     // sent and not yet answered
+	failed      atomic.Bool
+}
+
+// send sends msg, the transaction with the given ID, on the stream. After an
+// error the stream is marked failed and no longer picked until it is opened
+// again, and the transaction is left to the caller to give up.
+func (s *bidiStream) send(id int32, msg *pb.CalcMessage) error {
+	s.mu.Lock()
+	s.inflight[id] = msg
+	stream := s.stream
+	s.mu.Unlock()
+
+	// Send blocks while flow control holds the stream back, so mu is not
+	// held: the receiving goroutine needs it to make progress.
+	s.sendMu.Lock()
+	err := stream.Send(msg)
+	s.sendMu.Unlock()
+	if err != nil {
+		s.mu.Lock()
+		// A send on a stream that was replaced meanwhile does not fail the
+		// new one.
+		if s.stream == stream {
+			s.failed.Store(true)
+		}
+		// A reconnect does not send the message again.
+		if _, ok := s.inflight[id]; ok {
+			delete(s.inflight, id)
+			atomic.AddInt64(&s.outstanding, -1)
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// errSendClosed is returned for messages that arrive after the run
+// half-closed their stream.
+var errSendClosed = errors.New("stream already half-closed")
+
+// sendOn sends msg on stream, serialized with the other sends of s, unless s
+// is closed: a send after CloseSend would end the stream with the results
+// still on their way.
+func (s *bidiStream) sendOn(stream pb.CalculatorService_PerformCalculationBiClient, msg *pb.CalcMessage) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.closed {
+		return errSendClosed
+	}
+	return stream.Send(msg)
+}
+
+// closeSendLocked marks s closed and half-closes the current stream. s.mu
+// must be held.
+func (s *bidiStream) closeSendLocked() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.closed = true
+	return s.stream.CloseSend()
+}
+
+// currentLabels returns the tracker labels of the stream as it is open now.
+func (s *bidiStream) currentLabels() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.labels
+}
+
+// streamSet spreads the sends of a run over its streams.
+type streamSet struct {
+	streams []*bidiStream
+	policy  string
+	next    atomic.Uint64
+}
+
+// openStreams opens n streams over conns connections of pool, stream i on
+// connection i%conns. Labels are only added for the dimensions that have more
+// than one value.
+func openStreams(ctx context.Context, pool *connPool, conns, n int, policy string) *streamSet {
+	clients := pool.clients(conns)
+	set := &streamSet{policy: policy}
+	for i := 0; i < n; i++ {
+		conn := i % len(clients)
+		s := &bidiStream{name: fmt.Sprintf("stream=%d", i), inflight: make(map[int32]*pb.CalcMessage)}
+		// A reopened stream may land on another backend, so its labels are
+		// worked out anew.
+		s.open = func() (pb.CalculatorService_PerformCalculationBiClient, []string, error) {
+			stream, err := clients[conn].PerformCalculationBi(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			var labels []string
+			if n > 1 {
+				labels = append(labels, s.name)
+			}
+			if len(clients) > 1 {
+				labels = append(labels, fmt.Sprintf("conn=%d", conn))
+			}
+			return stream, append(labels, pool.backendLabel(stream.Context())...), nil
+		}
+		var err error
+		s.stream, s.labels, err = s.open()
+		if err != nil {
+			log.Fatalf("Failed to establish PerformCalculationBi stream %d: %v", i, err)
+		}
+		set.streams = append(set.streams, s)
+	}
+	return set
+}
+
+// pick returns the stream to send the next message on and counts the message
+// as outstanding on it, or nil if every stream has failed.
+func (set *streamSet) pick() *bidiStream {
+	var best *bidiStream
+	n := uint64(len(set.streams))
+	switch set.policy {
+	case policyLeastOutstanding:
+		i := lb.LeastOutstanding(len(set.streams), &set.next, func(i int) (int64, bool) {
+			s := set.streams[i]
+			return atomic.LoadInt64(&s.outstanding), !s.failed.Load()
+		})
+		if i >= 0 {
+			best = set.streams[i]
+		}
+	default:
+		for range set.streams {
+			s := set.streams[(set.next.Add(1)-1)%n]
+			if !s.failed.Load() {
+				best = s
+				break
+			}
+		}
+	}
+	if best != nil {
+		atomic.AddInt64(&best.outstanding, 1)
+	}
+	return best
+}
+
+// closeSend half-closes every stream. Streams still reconnecting are
+// half-closed once they are open again.
+func (set *streamSet) closeSend() {
+	for _, s := range set.streams {
+		s.mu.Lock()
+		if err := s.closeSendLocked(); err != nil {
+			log.Printf("Error closing bidirectional stream: %v", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// ackQueue is the number of ack frames a stream queues before it drops
+// them.
+const ackQueue = 1024
+
+// sendAcks sends an ack frame for every sequence number read from queue until
+// stop is closed. Unlike a failed send, a failed ack does not take the stream
+// out of rotation; the result is simply sent again.
+func (s *bidiStream) sendAcks(queue <-chan uint64, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case seq := <-queue:
+			s.mu.Lock()
+			stream := s.stream
+			s.mu.Unlock()
+			if err := s.sendOn(stream, &pb.CalcMessage{Ack: seq}); err != nil && *verbose {
+				log.Printf("Failed to acknowledge result %d: %v", seq, err)
+			}
+		}
+	}
+}
+
+// receive reads the responses of s until the run closes the stream and
+// records them. A stream that breaks before is opened again with rc. With
+// acks, every result read is acknowledged with an ack frame. The frames are
+// sent by another goroutine, so that reading never waits for a send that
+// flow control holds back; one that does not fit in the queue is dropped and
+// its result sent again.
+func (s *bidiStream) receive(acks bool, rc *reconnector) {
+	tracker, meter := rc.tracker, rc.meter
+	var ackCh chan uint64
+	if acks {
+		ackCh = make(chan uint64, ackQueue)
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.sendAcks(ackCh, stop)
+	}
+	for {
+		// Only this goroutine replaces the stream, so it may read it unlocked.
+		resp, err := s.stream.Recv()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			log.Printf("Bidirectional receive error on %s: %v", s.name, err)
+			// A result above the max message size breaks the stream.
+			if messageTooLarge(err) {
+				meter.AddError(errorKind(err, ""))
+			}
+			if !s.reconnect(err, rc) {
+				return
+			}
+			continue
+		}
+
+		payload, err := messagesigning.Verify(resp.GetPayload())
+		if err != nil {
+			log.Printf("Failed to verify response: %v", err)
+			meter.AddError("verify")
+			continue
+		}
+
+		respCalc, err := payloadCodec.Unmarshal(payload)
+		if err != nil {
+			log.Printf("Error reading response: %v", err)
+			meter.AddError("decode")
+			continue
+		}
+		s.mu.Lock()
+		delete(s.inflight, respCalc.ID)
+		s.mu.Unlock()
+		if entry, ok := tracker.RecordResponse(*respCalc); ok {
+			atomic.AddInt64(&s.outstanding, -1)
+			if *verbose {
+				log.Printf("Received response for ID=%d, Latency=%s, Response: %s", respCalc.ID, entry.Latency, respCalc.String())
+			}
+			meter.AddResponse()
+		} else if tracker.Answered(respCalc.ID) {
+			if *verbose {
+				log.Printf("Received duplicate response for ID=%d", respCalc.ID)
+			}
+		} else {
+			log.Printf("Received response for unknown ID=%d", respCalc.ID)
+			meter.AddError("unknown_id")
+		}
+		if acks && resp.GetSeq() != 0 {
+			select {
+			case ackCh <- resp.GetSeq():
+			default:
+				if *verbose {
+					log.Printf("Dropped the acknowledgement of result %d", resp.GetSeq())
+				}
+			}
+		}
+	}
+}
+
+// reconnect opens the stream again after it broke with cause and settles the
+// transactions it left unanswered in the background, so that the results of
+// those sent again are read meanwhile. It returns false if the run ended
+// first.
+func (s *bidiStream) reconnect(cause error, rc *reconnector) bool {
+	s.failed.Store(true)
+	rec, ok := rc.reconnect(s.name, cause, func() error {
+		stream, labels, err := s.open()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.stream, s.labels = stream, labels
+		if s.closed {
+			return s.closeSendLocked()
+		}
+		return nil
+	})
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	stream := s.stream
+	unanswered := maps.Clone(s.inflight)
+	s.mu.Unlock()
+	go func() {
+		ids := slices.Sorted(maps.Keys(unanswered))
+		resent, lost := rc.settle(ids, func(id int32) error {
+			return s.sendOn(stream, unanswered[id])
+		})
+		// Only the transactions sent again can still be answered on this
+		// stream.
+		for _, id := range resent {
+			delete(unanswered, id)
+		}
+		s.mu.Lock()
+		for id := range unanswered {
+			delete(s.inflight, id)
+		}
+		s.mu.Unlock()
+		atomic.AddInt64(&s.outstanding, int64(len(resent)-len(ids)))
+		s.failed.Store(false)
+
+		rec.Resent, rec.Lost = len(resent), lost
+		rc.record(rec)
+	}()
+	return true
+}
@@ -4,17 +4,29 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
+	"grpc-benchmark-study/internal/delivery"
 	"grpc-benchmark-study/internal/messagesigning"
 	"grpc-benchmark-study/internal/resources"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"grpc-benchmark-study/internal/calculation"
@@ -27,18 +39,160 @@ import (
 type calcServer struct {
 	pb.UnimplementedCalculatorServiceServer
 
-	// mu protects access to the clients map.
-	mu sync.Mutex
-	// clients maps a clientId (extracted from metadata) to its subscription channel.
-	clients map[string]chan *pb.CalcMessage
+	// clients routes results to the subscription of a clientId (extracted from metadata).
+	clients delivery.Backend
+	// mailbox keeps results for replay to reconnecting subscribers, nil if disabled.
+	mailbox *delivery.Mailbox
+	// acks configures the acknowledgement of bidirectional results.
+	acks delivery.AckOptions
+	// codecs are the codecs a client may encode its calculations with.
+	codecs []calculation.Codec
+	// maxResponseBytes is the most response padding a client may ask for.
+	maxResponseBytes int
+
+	// drainMu guards draining. PerformCalculationTo and the cluster's Deliver
+	// hold it for reading, so that drain waits for the calls in flight.
+	drainMu  sync.RWMutex
+	draining bool
+	// drained is closed once no more results are coming, to end the streams.
+	drained chan struct{}
+}
+
+func newCalcServer(clients delivery.Backend, mailbox *delivery.Mailbox, acks delivery.AckOptions, codecs []calculation.Codec, maxResponseBytes int) *calcServer {
+	return &calcServer{clients: clients, mailbox: mailbox, acks: acks, codecs: codecs, maxResponseBytes: maxResponseBytes, drained: make(chan struct{})}
+}
+
+// calcError returns the status a call fails with when PerformCalculation
+// fails with err.
+func calcError(err error) error {
+	if errors.Is(err, calculation.ErrResponseTooLarge) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, "unable to perform calculation")
+}
+
+// defaultMaxResponseBytes is the response padding limit of a server that does
+// not set -max-response-bytes, gRPC's default receive limit of a client.
+const defaultMaxResponseBytes = 4 << 20
+
+// errShuttingDown tells clients to retry on another server or after a restart.
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down")
+
+// drain rejects new PerformCalculationTo calls and forwarded results, waits
+// for those in flight to deliver their results and then ends the streams.
+func (s *calcServer) drain() {
+	s.drainMu.Lock()
+	s.draining = true
+	s.drainMu.Unlock()
+	close(s.drained)
+}
+
+// clusterServer receives the results peers forward to this server's subscribers.
+type clusterServer struct {
+	pb.UnimplementedClusterServiceServer
+
+	local *delivery.Local
+	// calc is drained together with the cluster service.
+	calc *calcServer
+}
+
+// Deliver implements the peer-to-peer RPC of a cluster. It validates the JWT
+// token and delivers the result only to a subscriber connected to this process.
+func (s *clusterServer) Deliver(ctx context.Context, req *pb.DeliverRequest) (*pb.DeliverResponse, error) {
+	if err := validateJWT(ctx); err != nil {
+		log.Printf("Deliver: JWT validation failed: %v", err)
+		return nil, err
+	}
+
+	// Take no forwarded results while shutting down.
+	s.calc.drainMu.RLock()
+	defer s.calc.drainMu.RUnlock()
+	if s.calc.draining {
+		return nil, errShuttingDown
+	}
+
+	// Sequence numbers are per server: the client acknowledges and resumes
+	// with those of the server it subscribed to, so this one numbers the
+	// result anew. Without a mailbox it cannot take either and the result
+	// carries none.
+	var dropped int
+	var err error
+	if s.calc.mailbox != nil {
+		dropped, err = s.calc.mailbox.Adopt(ctx, req.GetClientId(), req.GetMessage())
+	} else {
+		dropped, err = s.local.Deliver(ctx, req.GetClientId(), &pb.CalcMessage{Payload: req.GetMessage().GetPayload()})
+	}
+	switch {
+	case errors.Is(err, delivery.ErrNoSubscriber):
+		return &pb.DeliverResponse{Delivered: false}, nil
+	case errors.Is(err, delivery.ErrFull):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if *verbose {
+		log.Printf("Deliver: Delivered forwarded message to client %s", req.GetClientId())
+	}
+	return &pb.DeliverResponse{Delivered: true, Dropped: uint32(dropped)}, nil
 }
 
-func newCalcServer() *calcServer {
-	return &calcServer{
-		clients: make(map[string]chan *pb.CalcMessage),
+// codec returns the codec the calculations of a call are encoded with, as
+// named by the client in the calculation.CodecHeader metadata. A codec this
+// server does not accept is an InvalidArgument error, so that a mismatch
+// fails clearly instead of with decoding errors.
+func (s *calcServer) codec(ctx context.Context) (calculation.Codec, error) {
+	name := calculation.CodecJSON
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get(calculation.CodecHeader); len(vals) > 0 {
+		name = vals[0]
+	}
+	names := make([]string, len(s.codecs))
+	for i, c := range s.codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+		names[i] = c.Name()
 	}
+	return nil, status.Errorf(codes.InvalidArgument, "codec %q not accepted, this server accepts %s", name, strings.Join(names, ", "))
+}
+
+// sizeLogger logs the unary calls that fail on a message above a max message
+// size. gRPC refuses such a request before the handler runs, so they would
+// fail without a trace; the streaming handlers log their own receive errors.
+type sizeLogger struct{}
+
+// callInfo is what sizeLogger knows about a call.
+type callInfo struct {
+	method    string
+	streaming bool
 }
 
+type callInfoKey struct{}
+
+func (sizeLogger) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, &callInfo{method: info.FullMethodName})
+}
+
+func (sizeLogger) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	call, _ := ctx.Value(callInfoKey{}).(*callInfo)
+	if call == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.Begin:
+		call.streaming = s.IsClientStream || s.IsServerStream
+	case *stats.End:
+		st := status.Convert(s.Error)
+		if !call.streaming && st.Code() == codes.ResourceExhausted && strings.Contains(st.Message(), "larger than max") {
+			log.Printf("%s: %v", call.method, s.Error)
+		}
+	}
+}
+
+func (sizeLogger) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+func (sizeLogger) HandleConn(context.Context, stats.ConnStats) {}
+
 // validateJWT extracts the "authorization" header from the context and validates the JWT token.
 func validateJWT(ctx context.Context) error {
 	md, ok := metadata.FromIncomingContext(ctx)
@@ -72,12 +226,76 @@ func (s *calcServer) PerformCalculationBi(stream pb.CalculatorService_PerformCal
 		log.Printf("PerformCalculationBi: JWT validation failed: %v", err)
 		return err
 	}
+	codec, err := s.codec(stream.Context())
+	if err != nil {
+		log.Printf("PerformCalculationBi: %v", err)
+		return err
+	}
+
+	// Results are sent by this loop and by redeliveries, and Send is not safe
+	// for concurrent use.
+	var sendMu sync.Mutex
+	send := func(msg *pb.CalcMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(msg)
+	}
+
+	// With acknowledgements, results are numbered and sent again until the
+	// client acknowledges them.
+	var unacked *delivery.Unacked
+	if s.acks.Timeout > 0 {
+		unacked = delivery.NewUnacked(s.acks)
+		ctx, cancel := context.WithCancel(stream.Context())
+		gaveUp := make(chan int, 1)
+		go func() {
+			gaveUp <- redeliverBi(ctx, unacked, s.acks.Tick(), send)
+		}()
+		defer func() {
+			cancel()
+			if n := <-gaveUp; n > 0 || unacked.Len() > 0 {
+				log.Printf("PerformCalculationBi: Gave up on %d unacknowledged messages, %d still unacknowledged at the end", n, unacked.Len())
+			}
+		}()
+	}
+
+	// Receive in the background, so that a shutdown can end the stream
+	// between two messages.
+	msgs := make(chan *pb.CalcMessage)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
 
 	for {
-		msg, err := stream.Recv()
-		if err != nil {
+		var msg *pb.CalcMessage
+		select {
+		case msg = <-msgs:
+		case err := <-recvErr:
 			log.Printf("PerformCalculationBi: error receiving: %v", err)
 			return err
+		case <-s.drained:
+			log.Printf("PerformCalculationBi: Ending stream, server is shutting down")
+			return errShuttingDown
+		}
+
+		// An ack frame carries nothing but the acknowledged sequence number.
+		if msg.GetAck() != 0 && len(msg.GetPayload()) == 0 {
+			if unacked != nil {
+				unacked.Ack(msg.GetAck())
+			}
+			continue
 		}
 
 		if *verbose {
@@ -90,10 +308,10 @@ func (s *calcServer) PerformCalculationBi(stream pb.CalculatorService_PerformCal
 			return err
 		}
 
-		results, err := calculation.PerformCalculation(payload)
+		results, err := calculation.PerformCalculation(payload, codec, s.maxResponseBytes)
 		if err != nil {
 			log.Printf("PerformCalculationBi: error performing calculation: %v", err)
-			return err
+			return calcError(err)
 		}
 
 		signedMessage, err := messagesigning.Sign(results)
@@ -104,14 +322,78 @@ func (s *calcServer) PerformCalculationBi(stream pb.CalculatorService_PerformCal
 		response := &pb.CalcMessage{
 			Payload: signedMessage,
 		}
+		if unacked != nil {
+			unacked.Add(time.Now(), response)
+		}
 
-		if err := stream.Send(response); err != nil {
+		if err := send(response); err != nil {
 			log.Printf("PerformCalculationBi: error sending: %v", err)
 			return err
 		}
 	}
 }
 
+// redeliverBi sends the results of a bidirectional stream that were not
+// acknowledged in time again, until ctx is done, and returns the number of
+// results it gave up on.
+func redeliverBi(ctx context.Context, unacked *delivery.Unacked, tick time.Duration, send func(*pb.CalcMessage) error) int {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	total := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return total
+		case now := <-ticker.C:
+			msgs, gaveUp := unacked.Due(now)
+			total += gaveUp
+			for _, msg := range msgs {
+				if err := send(msg); err != nil {
+					return total
+				}
+			}
+			if *verbose && len(msgs) > 0 {
+				log.Printf("PerformCalculationBi: Redelivered %d messages", len(msgs))
+			}
+		}
+	}
+}
+
+// Ack implements a unary RPC.
+// It validates the JWT token, then drops the acknowledged results of the client in metadata from its mailbox.
+func (s *calcServer) Ack(ctx context.Context, req *pb.AckRequest) (*emptypb.Empty, error) {
+	// Validate JWT.
+	if err := validateJWT(ctx); err != nil {
+		log.Printf("Ack: JWT validation failed: %v", err)
+		return &emptypb.Empty{}, err
+	}
+
+	// Extract the client ID from metadata.
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		log.Printf("Ack: no metadata found")
+		return &emptypb.Empty{}, status.Error(codes.InvalidArgument, "metadata not provided")
+	}
+	clientIDs := md.Get("clientId")
+	if len(clientIDs) == 0 {
+		log.Printf("Ack: clientId not provided in metadata")
+		return &emptypb.Empty{}, status.Error(codes.InvalidArgument, "clientId header is missing")
+	}
+	clientID := clientIDs[0]
+
+	if s.mailbox == nil {
+		return &emptypb.Empty{}, status.Error(codes.FailedPrecondition, "acknowledgements need a mailbox")
+	}
+	if err := s.mailbox.Ack(clientID, req.GetSeqs()); err != nil {
+		log.Printf("Ack: failed to drop acknowledged messages of client %s: %v", clientID, err)
+		return &emptypb.Empty{}, status.Error(codes.Internal, "unable to drop acknowledged messages")
+	}
+	if *verbose {
+		log.Printf("Ack: Client %s acknowledged %d messages", clientID, len(req.GetSeqs()))
+	}
+	return &emptypb.Empty{}, nil
+}
+
 // PerformCalculationTo implements a unary RPC.
 // It validates the JWT token, then receives a CalcMessage and sends it only to the intended recipient based on msg.ClientId.
 func (s *calcServer) PerformCalculationTo(ctx context.Context, msg *pb.CalcMessage) (*emptypb.Empty, error) {
@@ -120,6 +402,18 @@ func (s *calcServer) PerformCalculationTo(ctx context.Context, msg *pb.CalcMessa
 		log.Printf("PerformCalculationTo: JWT validation failed: %v", err)
 		return &emptypb.Empty{}, err
 	}
+	codec, err := s.codec(ctx)
+	if err != nil {
+		log.Printf("PerformCalculationTo: %v", err)
+		return &emptypb.Empty{}, err
+	}
+
+	// Take no new work while shutting down.
+	s.drainMu.RLock()
+	defer s.drainMu.RUnlock()
+	if s.draining {
+		return &emptypb.Empty{}, errShuttingDown
+	}
 
 	// Extract the client ID from metadata.
 	md, ok := metadata.FromIncomingContext(ctx)
@@ -138,47 +432,160 @@ func (s *calcServer) PerformCalculationTo(ctx context.Context, msg *pb.CalcMessa
 		log.Printf("PerformCalculationTo: Received message for client %s", clientID)
 	}
 
-	// Look up the client for the given clientId.
-	s.mu.Lock()
-	ch, exists := s.clients[clientID]
-	s.mu.Unlock()
+	payload, err := messagesigning.Verify(msg.GetPayload())
+	if err != nil {
+		log.Printf("Failed to verify response: %v", err)
+		return &emptypb.Empty{}, status.Error(codes.Internal, "unable to verify message")
+	}
 
-	if exists {
+	results, err := calculation.PerformCalculation(payload, codec, s.maxResponseBytes)
+	if err != nil {
+		log.Printf("PerformCalculationTo: error performing calculation: %v", err)
+		return &emptypb.Empty{}, calcError(err)
+	}
 
-		payload, err := messagesigning.Verify(msg.GetPayload())
-		if err != nil {
-			log.Printf("Failed to verify response: %v", err)
-			return &emptypb.Empty{}, status.Error(codes.Internal, "unable to verify message")
+	signedMessage, err := messagesigning.Sign(results)
+	if err != nil {
+		log.Fatalf("Failed to sign message: %v", err)
+	}
+
+	response := &pb.CalcMessage{
+		Payload: signedMessage,
+	}
+
+	// Hand the result to the client's subscription, wherever it is connected.
+	dropped, err := s.clients.Deliver(ctx, clientID, response)
+	switch {
+	case err == nil && dropped > 0:
+		if *verbose {
+			log.Printf("PerformCalculationTo: Channel for client %s is full, dropped %d messages", clientID, dropped)
 		}
+		// Tell the client how many results it will never see.
+		grpc.SetTrailer(ctx, metadata.Pairs("dropped", strconv.Itoa(dropped)))
+	case err == nil:
+		if *verbose {
+			log.Printf("PerformCalculationTo: Sent message to client %s", clientID)
+		}
+	case errors.Is(err, delivery.ErrNoSubscriber):
+		log.Printf("PerformCalculationTo: No subscriber for client %s", clientID)
+	case errors.Is(err, delivery.ErrKept):
+		if *verbose {
+			log.Printf("PerformCalculationTo: No subscriber for client %s, kept message %d in mailbox", clientID, response.Seq)
+		}
+	case errors.Is(err, delivery.ErrFull):
+		if *verbose {
+			log.Printf("PerformCalculationTo: Channel for client %s is full, rejecting message", clientID)
+		}
+		return &emptypb.Empty{}, status.Error(codes.ResourceExhausted, "subscriber buffer full")
+	default:
+		log.Printf("PerformCalculationTo: Failed to deliver message to client %s: %v", clientID, err)
+	}
 
-		results, err := calculation.PerformCalculation(payload)
+	return &emptypb.Empty{}, nil
+}
+
+// PerformCalculation implements a unary RPC.
+// It validates the JWT token, then performs the calculation and returns the signed result as the response.
+func (s *calcServer) PerformCalculation(ctx context.Context, msg *pb.CalcMessage) (*pb.CalcMessage, error) {
+	// Validate JWT.
+	if err := validateJWT(ctx); err != nil {
+		log.Printf("PerformCalculation: JWT validation failed: %v", err)
+		return nil, err
+	}
+	codec, err := s.codec(ctx)
+	if err != nil {
+		log.Printf("PerformCalculation: %v", err)
+		return nil, err
+	}
+
+	// Take no new work while shutting down.
+	s.drainMu.RLock()
+	defer s.drainMu.RUnlock()
+	if s.draining {
+		return nil, errShuttingDown
+	}
+
+	if *verbose {
+		log.Printf("PerformCalculation: Received message")
+	}
+
+	payload, err := messagesigning.Verify(msg.GetPayload())
+	if err != nil {
+		log.Printf("Failed to verify response: %v", err)
+		return nil, status.Error(codes.Internal, "unable to verify message")
+	}
+
+	results, err := calculation.PerformCalculation(payload, codec, s.maxResponseBytes)
+	if err != nil {
+		log.Printf("PerformCalculation: error performing calculation: %v", err)
+		return nil, calcError(err)
+	}
+
+	signedMessage, err := messagesigning.Sign(results)
+	if err != nil {
+		log.Fatalf("Failed to sign message: %v", err)
+	}
+
+	return &pb.CalcMessage{
+		Payload: signedMessage,
+	}, nil
+}
+
+// PerformCalculationBatch implements a client streaming RPC.
+// It validates the JWT token, then performs the calculation of every incoming CalcMessage and returns all signed
+// results in one CalcBatchResult once the client closes the stream.
+func (s *calcServer) PerformCalculationBatch(stream pb.CalculatorService_PerformCalculationBatchServer) error {
+	// Validate JWT from the stream context.
+	if err := validateJWT(stream.Context()); err != nil {
+		log.Printf("PerformCalculationBatch: JWT validation failed: %v", err)
+		return err
+	}
+	codec, err := s.codec(stream.Context())
+	if err != nil {
+		log.Printf("PerformCalculationBatch: %v", err)
+		return err
+	}
+
+	// Take no new batches while shutting down.
+	s.drainMu.RLock()
+	draining := s.draining
+	s.drainMu.RUnlock()
+	if draining {
+		return errShuttingDown
+	}
+
+	var results []*pb.CalcMessage
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			if *verbose {
+				log.Printf("PerformCalculationBatch: Returning %d results", len(results))
+			}
+			return stream.SendAndClose(&pb.CalcBatchResult{Results: results})
+		}
 		if err != nil {
-			log.Printf("PerformCalculationTo: error performing calculation: %v", err)
-			return &emptypb.Empty{}, status.Error(codes.Internal, "unable to perform calculation")
+			log.Printf("PerformCalculationBatch: error receiving: %v", err)
+			return err
 		}
 
-		signedMessage, err := messagesigning.Sign(results)
+		payload, err := messagesigning.Verify(msg.GetPayload())
 		if err != nil {
-			log.Fatalf("Failed to sign message: %v", err)
+			log.Printf("Failed to verify response: %v", err)
+			return status.Error(codes.Internal, "unable to verify message")
 		}
 
-		response := &pb.CalcMessage{
-			Payload: signedMessage,
+		result, err := calculation.PerformCalculation(payload, codec, s.maxResponseBytes)
+		if err != nil {
+			log.Printf("PerformCalculationBatch: error performing calculation: %v", err)
+			return calcError(err)
 		}
 
-		select {
-		case ch <- response:
-			if *verbose {
-				log.Printf("PerformCalculationTo: Sent message to client %s", clientID)
-			}
-		default:
-			log.Printf("PerformCalculationTo: Channel for client %s is full, dropping message", clientID)
+		signedMessage, err := messagesigning.Sign(result)
+		if err != nil {
+			log.Fatalf("Failed to sign message: %v", err)
 		}
-	} else {
-		log.Printf("PerformCalculationTo: No subscriber for client %s", clientID)
+		results = append(results, &pb.CalcMessage{Payload: signedMessage})
 	}
-
-	return &emptypb.Empty{}, nil
 }
 
 // PerformCalculationFrom implements a server streaming RPC.
@@ -204,36 +611,96 @@ func (s *calcServer) PerformCalculationFrom(empty *emptypb.Empty, stream pb.Calc
 	}
 	clientID := clientIDs[0]
 
-	// Create a new channel for this client.
-	ch := make(chan *pb.CalcMessage, 10)
+	// A reconnecting client passes the sequence number of the last result it
+	// saw to resume after it. Only a mailbox numbers results, and it numbers
+	// those forwarded by peers anew when it adopts them, so every number on
+	// the subscription comes from this server's mailbox. Without one there is
+	// nothing to resume.
+	var lastSeq uint64
+	resume := false
+	if vals := md.Get("last-seq"); len(vals) > 0 && s.mailbox != nil {
+		seq, err := strconv.ParseUint(vals[0], 10, 64)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid last-seq header %q", vals[0])
+		}
+		lastSeq, resume = seq, true
+	}
 
 	// Register the client.
-	s.mu.Lock()
-	s.clients[clientID] = ch
-	s.mu.Unlock()
+	sub, err := s.clients.Subscribe(clientID)
+	if errors.Is(err, delivery.ErrDuplicate) {
+		log.Printf("PerformCalculationFrom: Client %s is already subscribed", clientID)
+		return status.Errorf(codes.AlreadyExists, "client %s is already subscribed", clientID)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to subscribe: %v", err)
+	}
 
 	log.Printf("PerformCalculationFrom: New client %s connected", clientID)
 
 	// Ensure cleanup when stream ends.
 	defer func() {
-		s.mu.Lock()
-		delete(s.clients, clientID)
-		s.mu.Unlock()
-		close(ch)
-		log.Printf("PerformCalculationFrom: Client %s disconnected", clientID)
+		sub.Cancel()
+		// Report the results lost to the overflow policy over the whole subscription.
+		stream.SetTrailer(metadata.Pairs("dropped", strconv.FormatInt(sub.Dropped(), 10)))
+		log.Printf("PerformCalculationFrom: Client %s disconnected, %d messages dropped", clientID, sub.Dropped())
 	}()
 
+	// The header tells the client its subscription is in place.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	// Replay the kept results the client missed. Results delivered live in
+	// the meantime are kept as well, so the live stream skips up to the last
+	// replayed one.
+	if resume {
+		replay := s.mailbox.Replay(clientID, lastSeq)
+		log.Printf("PerformCalculationFrom: Replaying %d messages to client %s after %d", len(replay), clientID, lastSeq)
+		for _, msg := range replay {
+			if err := stream.Send(msg); err != nil {
+				log.Printf("PerformCalculationFrom: error replaying to client %s: %v", clientID, err)
+				return err
+			}
+			lastSeq = msg.Seq
+		}
+	}
+
+	send := func(msg *pb.CalcMessage) error {
+		if resume && msg.Seq != 0 && msg.Seq <= lastSeq {
+			return nil
+		}
+		if err := stream.Send(msg); err != nil {
+			log.Printf("PerformCalculationFrom: error sending to client %s: %v", clientID, err)
+			return err
+		}
+		return nil
+	}
+
 	// Stream messages to the client.
 	for {
 		select {
-		case msg, ok := <-ch:
-			if !ok {
-				return nil
+		case <-sub.Done:
+			// A newer subscription took over; end this one cleanly.
+			if errors.Is(sub.Err(), delivery.ErrTakenOver) {
+				log.Printf("PerformCalculationFrom: Subscription of client %s taken over", clientID)
 			}
-			if err := stream.Send(msg); err != nil {
-				log.Printf("PerformCalculationFrom: error sending to client %s: %v", clientID, err)
+			return nil
+		case msg := <-sub.C:
+			if err := send(msg); err != nil {
 				return err
 			}
+		case <-s.drained:
+			// No more results are coming: flush the buffered ones, then tell
+			// the client to subscribe again elsewhere.
+			n := len(sub.C)
+			for range n {
+				if err := send(<-sub.C); err != nil {
+					return err
+				}
+			}
+			log.Printf("PerformCalculationFrom: Flushed %d messages to client %s, server is shutting down", n, clientID)
+			return errShuttingDown
 		case <-stream.Context().Done():
 			log.Printf("PerformCalculationFrom: client %s context done", clientID)
 			return stream.Context().Err()
@@ -241,6 +708,63 @@ func (s *calcServer) PerformCalculationFrom(empty *emptypb.Empty, stream pb.Calc
 	}
 }
 
+// peerToken authenticates the calls to a peer with a JWT token, renewed
+// before it expires.
+type peerToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *peerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Until(t.expires) < time.Minute {
+		t.expires = time.Now().Add(time.Hour)
+		token, err := jwtutil.GenerateToken(jwt.MapClaims{
+			"sub": "cluster-peer",
+			"iat": time.Now().Unix(),
+			"exp": t.expires.Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		t.token = token
+	}
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t *peerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+// dialPeer connects to another server of the cluster with the embedded client
+// certificate. The connection is made lazily, so peers may start in any order.
+func dialPeer(addr string, caCertPool *x509.CertPool) pb.ClusterServiceClient {
+	certBytes, err := resources.Certs.ReadFile("certs/client.crt")
+	if err != nil {
+		log.Fatalf("Failed to read embedded client.crt: %v", err)
+	}
+	keyBytes, err := resources.Certs.ReadFile("certs/client.key")
+	if err != nil {
+		log.Fatalf("Failed to read embedded client.key: %v", err)
+	}
+	clientCert, err := tls.X509KeyPair(certBytes, keyBytes)
+	if err != nil {
+		log.Fatalf("Failed to load X509 key pair from embedded certs: %v", err)
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      caCertPool,
+		ServerName:   "localhost",
+	})
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(&peerToken{}))
+	if err != nil {
+		log.Fatalf("Failed to create connection to peer %s: %v", addr, err)
+	}
+	return pb.NewClusterServiceClient(conn)
+}
+
 var verbose *bool
 
 func main() {
@@ -248,10 +772,63 @@ func main() {
 	listenIP := flag.String("ip", "0.0.0.0", "Listen IP address")
 	port := flag.String("port", "50051", "Listen port")
 	verbose = flag.Bool("verbose", false, "Verbose output")
+	deliveryMode := flag.String("delivery", "local", "Result delivery backend: 'local' (subscribers of this process only) or 'cluster' (forward to -peers)")
+	peers := flag.String("peers", "", "Comma-separated host:port list of the other servers of the cluster")
+	buffer := flag.Int("buffer", delivery.DefaultOptions.Buffer, "Number of results a PerformCalculationFrom subscriber can fall behind by")
+	overflowFlag := flag.String("overflow", string(delivery.DefaultOptions.Overflow), "What to do with a result for a full subscriber: drop-newest, drop-oldest, block or reject")
+	blockTimeout := flag.Duration("block-timeout", 100*time.Millisecond, "How long the block overflow policy waits for room before dropping")
+	mailboxSize := flag.Int("mailbox-size", 0, "Number of results kept per client for replay to reconnecting subscribers, 0 to disable the mailbox")
+	mailboxTTL := flag.Duration("mailbox-ttl", time.Minute, "How long the mailbox keeps a result, 0 for no limit")
+	mailboxDir := flag.String("mailbox-dir", "", "Directory to persist mailboxes in, so that they survive a restart")
+	ackTimeout := flag.Duration("ack-timeout", 0, "Send results again that the client did not acknowledge within this time, 0 to disable acknowledgements")
+	maxRedeliveries := flag.Int("max-redeliveries", 5, "How often an unacknowledged result is sent again before it is given up")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "How long a shutdown waits for calls and streams to end before closing the connections")
+	codecsFlag := flag.String("codecs", calculation.CodecJSON, "Comma-separated codecs the clients may encode their calculations with: "+strings.Join(calculation.CodecNames(), ", "))
+	maxRecvMsgSize := flag.Int("max-recv-msg-size", 0, "Largest message in bytes the server receives (0 for gRPC's default of 4 MiB)")
+	maxSendMsgSize := flag.Int("max-send-msg-size", 0, "Largest message in bytes the server sends (0 for no limit)")
+	maxResponseBytes := flag.Int("max-response-bytes", defaultMaxResponseBytes, "Largest response padding in bytes a client may ask for")
+	duplicatesFlag := flag.String("duplicates", string(delivery.DefaultOptions.Duplicates), "What to do when a subscribed client subscribes again: reject, takeover, fanout or loadshare")
+	payloadFlag := flag.String("payload", "", "Deprecated: use -codecs")
 	flag.Parse()
 
+	// -payload is the deprecated name of -codecs, which wins when both are given.
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["payload"] {
+		log.Printf("-payload is deprecated, use -codecs instead")
+		if !set["codecs"] {
+			*codecsFlag = *payloadFlag
+		}
+	}
+
+	overflow, err := delivery.ParseOverflow(*overflowFlag)
+	if err != nil {
+		log.Fatalf("Invalid overflow policy: %v", err)
+	}
+	duplicates, err := delivery.ParseDuplicates(*duplicatesFlag)
+	if err != nil {
+		log.Fatalf("Invalid duplicate subscriber mode: %v", err)
+	}
+	if *buffer < 0 {
+		log.Fatalf("Invalid buffer size: %d", *buffer)
+	}
+	var codecs []calculation.Codec
+	for _, name := range strings.Split(*codecsFlag, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		codec, err := calculation.GetCodec(name)
+		if err != nil {
+			log.Fatalf("Invalid codec: %v", err)
+		}
+		codecs = append(codecs, codec)
+	}
+	if len(codecs) == 0 {
+		log.Fatalf("At least one codec must be accepted in -codecs")
+	}
+
 	// Load JWT Pub Key
-	err := jwtutil.LoadKeys("jwt/jwt.key", "jwt/jwt.pub")
+	err = jwtutil.LoadKeys("jwt/jwt.key", "jwt/jwt.pub")
 	if err != nil {
 		log.Fatalf("Unable to load public key: %v", err)
 	}
@@ -304,12 +881,99 @@ func main() {
 	// Create gRPC credentials.
 	creds := credentials.NewTLS(tlsConfig)
 
-	// Create a new gRPC server with TLS enabled.
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
-	pb.RegisterCalculatorServiceServer(grpcServer, newCalcServer())
+	// Shut down on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Pick the delivery backend.
+	local := delivery.NewLocal(delivery.Options{Buffer: *buffer, Overflow: overflow, BlockTimeout: *blockTimeout, Duplicates: duplicates})
+	var backend delivery.Backend
+	switch *deliveryMode {
+	case "local":
+		backend = local
+	case "cluster":
+		var clients []pb.ClusterServiceClient
+		for _, addr := range strings.Split(*peers, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				clients = append(clients, dialPeer(addr, caCertPool))
+			}
+		}
+		if len(clients) == 0 {
+			log.Fatalf("Cluster delivery needs at least one peer in -peers")
+		}
+		log.Printf("Forwarding results for unknown clients to %d peers", len(clients))
+		backend = delivery.NewCluster(local, clients)
+	default:
+		log.Fatalf("Invalid delivery mode: %s. Allowed values are 'local' or 'cluster'.", *deliveryMode)
+	}
+
+	// Keep results for replay if asked to. Unary results can only be
+	// redelivered from the mailbox.
+	acks := delivery.AckOptions{Timeout: *ackTimeout, MaxRedeliveries: *maxRedeliveries}
+	var mailbox *delivery.Mailbox
+	if *mailboxSize > 0 {
+		mailbox, err = delivery.NewMailbox(backend, delivery.MailboxOptions{Size: *mailboxSize, TTL: *mailboxTTL, Dir: *mailboxDir, Acks: acks})
+		if err != nil {
+			log.Fatalf("Failed to open mailbox: %v", err)
+		}
+		defer mailbox.Close()
+		log.Printf("Keeping up to %d results per client for %s", *mailboxSize, *mailboxTTL)
+		backend = mailbox
+		go mailbox.Redeliver(ctx, func(clientID string, n int) {
+			if *verbose {
+				log.Printf("Gave up on %d unacknowledged messages for client %s", n, clientID)
+			}
+		})
+	}
+	if acks.Timeout > 0 {
+		log.Printf("Redelivering unacknowledged results after %s, up to %d times", acks.Timeout, acks.MaxRedeliveries)
+		if mailbox == nil {
+			log.Printf("Unary results are only redelivered with a mailbox (-mailbox-size)")
+		}
+	}
+
+	// Create a new gRPC server with TLS enabled. Messages above a size limit
+	// fail their call with ResourceExhausted.
+	serverOpts := []grpc.ServerOption{grpc.Creds(creds), grpc.StatsHandler(sizeLogger{})}
+	if *maxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(*maxRecvMsgSize))
+	}
+	if *maxSendMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(*maxSendMsgSize))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
+	calc := newCalcServer(backend, mailbox, acks, codecs, *maxResponseBytes)
+	pb.RegisterCalculatorServiceServer(grpcServer, calc)
+	pb.RegisterClusterServiceServer(grpcServer, &clusterServer{local: local, calc: calc})
+
+	// On shutdown, refuse new connections and calls, flush the subscribers
+	// and give the streams until the drain timeout to end before closing them.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		stop() // A second signal kills the process.
+		log.Printf("Shutting down, draining for up to %s", *drainTimeout)
+		timer := time.NewTimer(*drainTimeout)
+		defer timer.Stop()
+		graceful := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(graceful)
+		}()
+		go calc.drain()
+		select {
+		case <-graceful:
+		case <-timer.C:
+			log.Printf("Drain timed out, closing the remaining connections")
+			grpcServer.Stop()
+		}
+	}()
 
 	// Start serving.
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
+	<-stopped
+	log.Printf("Server stopped")
 }
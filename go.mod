@@ -3,14 +3,21 @@ module grpc-benchmark-study
 go 1.23
 
 require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/github/smimesign v0.2.0
 	github.com/golang-jwt/jwt/v4 v4.5.1
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 	gonum.org/v1/gonum v0.15.1
 	google.golang.org/grpc v1.70.0
 	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
 	golang.org/x/net v0.32.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
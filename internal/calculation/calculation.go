@@ -1,38 +1,44 @@
 package calculation
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
 )
 
 // Calculation holds the information for a calculation request and response.
+// Padding only makes the message bigger. A response carries the padding of
+// its request, or ResponseBytes of padding if the request asks for that.
 type Calculation struct {
-	ID        int32  `json:"id"`
-	X         int    `json:"x"`
-	Y         int    `json:"y"`
-	Operation string `json:"operation"`
-	Result    int    `json:"result"`
-	Prime     bool   `json:"isPrime"`
+	ID            int32  `json:"id"`
+	X             int    `json:"x"`
+	Y             int    `json:"y"`
+	Operation     string `json:"operation"`
+	Result        int    `json:"result"`
+	Prime         bool   `json:"isPrime"`
+	Padding       []byte `json:"padding,omitempty"`
+	ResponseBytes int    `json:"responseBytes,omitempty"`
 }
 
-func Read(input []byte) (*Calculation, error) {
-	var calc Calculation
-	if err := json.Unmarshal(input, &calc); err != nil {
-		return nil, err
-	}
-	return &calc, nil
-}
+// ErrResponseTooLarge is returned by PerformCalculation for a request that
+// asks for more response padding than allowed.
+var ErrResponseTooLarge = errors.New("requested response padding too large")
 
-// PerformCalculation unmarshals the input JSON into a Calculation,
+// PerformCalculation decodes the input into a Calculation with codec,
 // determines which operation to perform, executes it, and returns the
-// resulting Calculation as JSON.
-func PerformCalculation(input []byte) ([]byte, error) {
-	var calc Calculation
-	if err := json.Unmarshal(input, &calc); err != nil {
+// resulting Calculation encoded with the same codec. A request may ask for
+// up to maxResponseBytes of response padding.
+func PerformCalculation(input []byte, codec Codec, maxResponseBytes int) ([]byte, error) {
+	calc, err := codec.Unmarshal(input)
+	if err != nil {
 		return nil, err
 	}
+	if calc.ResponseBytes > maxResponseBytes {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrResponseTooLarge, calc.ResponseBytes, maxResponseBytes)
+	}
 
 	// Normalize the operation string to uppercase.
 	op := strings.ToUpper(calc.Operation)
@@ -46,8 +52,12 @@ func PerformCalculation(input []byte) ([]byte, error) {
 	default:
 		return nil, fmt.Errorf("unknown operation: %s", calc.Operation)
 	}
+	if calc.ResponseBytes > 0 {
+		calc.Padding = resizePadding(calc.Padding, calc.ResponseBytes)
+		calc.ResponseBytes = 0
+	}
 
-	return calc.Bytes()
+	return codec.Marshal(calc)
 }
 
 // Add performs an addition of X and Y, storing the result.
@@ -77,9 +87,37 @@ func (c *Calculation) IsPrime() {
 	c.Prime = true
 }
 
-// Bytes serializes the Calculation to JSON.
-func (c *Calculation) Bytes() ([]byte, error) {
-	return json.Marshal(c)
+// Proto returns c as a Calculation message. Its operation must be one of the
+// Operation values.
+func (c *Calculation) Proto() (*pb.Calculation, error) {
+	op, ok := pb.Operation_value["OPERATION_"+strings.ToUpper(c.Operation)]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation: %s", c.Operation)
+	}
+	return &pb.Calculation{
+		Id:            c.ID,
+		X:             int64(c.X),
+		Y:             int64(c.Y),
+		Operation:     pb.Operation(op),
+		Result:        int64(c.Result),
+		Prime:         c.Prime,
+		Padding:       c.Padding,
+		ResponseBytes: int64(c.ResponseBytes),
+	}, nil
+}
+
+// FromProto returns the Calculation held by the message m.
+func FromProto(m *pb.Calculation) *Calculation {
+	return &Calculation{
+		ID:            m.GetId(),
+		X:             int(m.GetX()),
+		Y:             int(m.GetY()),
+		Operation:     strings.TrimPrefix(m.GetOperation().String(), "OPERATION_"),
+		Result:        int(m.GetResult()),
+		Prime:         m.GetPrime(),
+		Padding:       m.GetPadding(),
+		ResponseBytes: int(m.GetResponseBytes()),
+	}
 }
 
 // String returns a key=value representation of the Calculation.
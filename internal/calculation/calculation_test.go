@@ -0,0 +1,134 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func sample() *Calculation {
+	return &Calculation{ID: 42, X: 1000000000037, Y: 1, Operation: "ISPRIME", Result: -7, Prime: true, Padding: []byte("pad"), ResponseBytes: 5}
+}
+
+func mustCodec(t testing.TB, name string) Codec {
+	t.Helper()
+	c, err := GetCodec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, name := range CodecNames() {
+		t.Run(name, func(t *testing.T) {
+			c := mustCodec(t, name)
+			data, err := c.Marshal(sample())
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			got, err := c.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, sample()) {
+				t.Errorf("round trip = %+v, want %+v", *got, *sample())
+			}
+		})
+	}
+}
+
+func TestGetCodecUnknown(t *testing.T) {
+	if _, err := GetCodec("xml"); err == nil {
+		t.Error("GetCodec(\"xml\") succeeded")
+	}
+}
+
+func TestPerformCalculation(t *testing.T) {
+	for _, name := range CodecNames() {
+		c := mustCodec(t, name)
+		input, err := c.Marshal(&Calculation{ID: 1, X: 3, Y: 1, Operation: "SUBTRACT"})
+		if err != nil {
+			t.Fatalf("%s: Marshal failed: %v", name, err)
+		}
+		output, err := PerformCalculation(input, c, 1<<20)
+		if err != nil {
+			t.Fatalf("%s: PerformCalculation failed: %v", name, err)
+		}
+		got, err := c.Unmarshal(output)
+		if err != nil || got.Result != 2 {
+			t.Errorf("%s: result = %+v, %v; want 2", name, got, err)
+		}
+	}
+}
+
+func TestResponsePadding(t *testing.T) {
+	c := mustCodec(t, CodecJSON)
+	tests := []struct {
+		padding       string
+		responseBytes int
+		want          string
+	}{
+		{"abc", 0, "abc"},
+		{"abc", 7, "abcabca"},
+		{"abc", 2, "ab"},
+	}
+	for _, tt := range tests {
+		input, _ := c.Marshal(&Calculation{Operation: "ADD", Padding: []byte(tt.padding), ResponseBytes: tt.responseBytes})
+		output, err := PerformCalculation(input, c, 1<<20)
+		if err != nil {
+			t.Fatalf("PerformCalculation failed: %v", err)
+		}
+		got, _ := c.Unmarshal(output)
+		if string(got.Padding) != tt.want || got.ResponseBytes != 0 {
+			t.Errorf("padding %q, response bytes %d: response padding = %q, response bytes %d; want %q, 0",
+				tt.padding, tt.responseBytes, got.Padding, got.ResponseBytes, tt.want)
+		}
+	}
+
+	input, _ := c.Marshal(&Calculation{Operation: "ADD", ResponseBytes: 100})
+	output, _ := PerformCalculation(input, c, 1<<20)
+	if got, _ := c.Unmarshal(output); len(got.Padding) != 100 {
+		t.Errorf("response padding without request padding has %d bytes, want 100", len(got.Padding))
+	}
+
+	input, _ = c.Marshal(&Calculation{Operation: "ADD", ResponseBytes: 1<<20 + 1})
+	if _, err := PerformCalculation(input, c, 1<<20); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("response padding above the limit: err = %v, want ErrResponseTooLarge", err)
+	}
+}
+
+// BenchmarkCodecs measures every registered codec on a single Calculation,
+// without and with 64 KiB of padding, and reports the size of its encoding.
+func BenchmarkCodecs(b *testing.B) {
+	for _, name := range CodecNames() {
+		c := mustCodec(b, name)
+		for _, size := range []int{0, 64 << 10} {
+			calc := &Calculation{ID: 42, X: 1000000000037, Y: 1, Operation: "ISPRIME", Prime: true}
+			calc.Padding, _ = NewPadding(size, PaddingRandom)
+			data, err := c.Marshal(calc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s/padding=%d/marshal", name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Marshal(calc); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "bytes/msg")
+			})
+			b.Run(fmt.Sprintf("%s/padding=%d/unmarshal", name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Unmarshal(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "bytes/msg")
+			})
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package calculation
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// CodecHeader is the metadata key a client names the codec of its payloads
+// in. A call without it uses CodecJSON.
+const CodecHeader = "calc-codec"
+
+// Names of the built-in codecs.
+const (
+	CodecJSON    = "json"
+	CodecProto   = "proto" // a calculator.Calculation message
+	CodecGob     = "gob"
+	CodecCBOR    = "cbor"
+	CodecMsgpack = "msgpack"
+)
+
+// Codec encodes a Calculation into the signed payload of a CalcMessage and
+// back.
+type Codec interface {
+	// Name is the name the codec is registered and negotiated under.
+	Name() string
+	Marshal(c *Calculation) ([]byte, error)
+	Unmarshal(data []byte) (*Calculation, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{jsonCodec{}, protoCodec{}, gobCodec{}, cborCodec{}, msgpackCodec{}} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec makes c available under its name, replacing any codec
+// registered under the same name.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// GetCodec returns the codec registered under name.
+func GetCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	c, ok := codecs[name]
+	codecsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q, available: %s", name, strings.Join(CodecNames(), ", "))
+	}
+	return c, nil
+}
+
+// CodecNames returns the names of the registered codecs, sorted.
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return CodecJSON }
+
+func (jsonCodec) Marshal(c *Calculation) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (*Calculation, error) {
+	var calc Calculation
+	if err := json.Unmarshal(data, &calc); err != nil {
+		return nil, err
+	}
+	return &calc, nil
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return CodecProto }
+
+func (protoCodec) Marshal(c *Calculation) ([]byte, error) {
+	m, err := c.Proto()
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte) (*Calculation, error) {
+	var m pb.Calculation
+	if err := proto.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return FromProto(&m), nil
+}
+
+// gobCodec writes every payload with a fresh encoder, so each one carries
+// the type description of Calculation along with the values.
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return CodecGob }
+
+func (gobCodec) Marshal(c *Calculation) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte) (*Calculation, error) {
+	var calc Calculation
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&calc); err != nil {
+		return nil, err
+	}
+	return &calc, nil
+}
+
+// cborCodec keys the fields by the names of their json tags.
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return CodecCBOR }
+
+func (cborCodec) Marshal(c *Calculation) ([]byte, error) {
+	return cbor.Marshal(c)
+}
+
+func (cborCodec) Unmarshal(data []byte) (*Calculation, error) {
+	var calc Calculation
+	if err := cbor.Unmarshal(data, &calc); err != nil {
+		return nil, err
+	}
+	return &calc, nil
+}
+
+// msgpackCodec keys the fields by their Go names.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+
+func (msgpackCodec) Marshal(c *Calculation) ([]byte, error) {
+	return msgpack.Marshal(c)
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (*Calculation, error) {
+	var calc Calculation
+	if err := msgpack.Unmarshal(data, &calc); err != nil {
+		return nil, err
+	}
+	return &calc, nil
+}
@@ -0,0 +1,51 @@
+package calculation
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Kinds of padding.
+const (
+	PaddingRandom       = "random"       // incompressible
+	PaddingCompressible = "compressible" // a repeated text
+)
+
+// compressibleText is repeated to fill compressible padding.
+const compressibleText = "The quick brown fox jumps over the lazy dog. "
+
+// NewPadding returns n bytes of padding of the given kind.
+func NewPadding(n int, kind string) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid padding size: %d", n)
+	}
+	p := make([]byte, n)
+	switch kind {
+	case PaddingRandom:
+		rand.Read(p)
+	case PaddingCompressible:
+		fill(p, []byte(compressibleText))
+	default:
+		return nil, fmt.Errorf("unknown padding kind: %s", kind)
+	}
+	return p, nil
+}
+
+// resizePadding returns n bytes of padding that repeat p, or random bytes if
+// p is empty.
+func resizePadding(p []byte, n int) []byte {
+	if len(p) == 0 {
+		p, _ = NewPadding(n, PaddingRandom)
+		return p
+	}
+	resized := make([]byte, n)
+	fill(resized, p)
+	return resized
+}
+
+// fill fills dst with repetitions of pattern.
+func fill(dst, pattern []byte) {
+	for i := 0; i < len(dst); i += len(pattern) {
+		copy(dst[i:], pattern)
+	}
+}
@@ -0,0 +1,239 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/stat/distuv"
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/tracking"
+)
+
+// Thresholds configures when a metric counts as regressed. Percentages are
+// relative to the baseline, ErrorRateIncrease is in percentage points. A zero
+// threshold disables the check for that metric. A change past its threshold
+// is only a regression if it is also significant at level Alpha (or if there
+// are too few per-second samples to test).
+type Thresholds struct {
+	TPSDrop           float64
+	P50Increase       float64
+	P99Increase       float64
+	MaxIncrease       float64
+	ErrorRateIncrease float64
+	Alpha             float64
+}
+
+// Metric is the comparison of one metric between two runs.
+type Metric struct {
+	Name        string
+	Baseline    float64
+	Candidate   float64
+	DeltaPct    float64 // relative change in percent (percentage points for error_rate_pct)
+	PValue      float64 // two-sided Mann-Whitney p-value over per-second samples, NaN if untested
+	Significant bool
+	Regression  bool
+}
+
+// Result holds all compared metrics.
+type Result struct {
+	Metrics []Metric
+}
+
+// Regressed reports whether any metric regressed.
+func (r Result) Regressed() bool {
+	for _, m := range r.Metrics {
+		if m.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the comparison as an aligned table.
+func (r Result) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-18s %12s %12s %10s %9s  %s\n", "Metric", "Baseline", "Candidate", "Delta", "p-value", "Verdict")
+	for _, m := range r.Metrics {
+		p := "n/a"
+		if !math.IsNaN(m.PValue) {
+			p = fmt.Sprintf("%.4f", m.PValue)
+		}
+		unit := "%"
+		if m.Name == "error_rate_pct" {
+			unit = "pp"
+		}
+		verdict := "ok"
+		switch {
+		case m.Regression:
+			verdict = "REGRESSION"
+		case m.Significant:
+			verdict = "changed (significant)"
+		}
+		fmt.Fprintf(&b, "%-18s %12.3f %12.3f %+8.2f%-2s %9s  %s\n",
+			m.Name, m.Baseline, m.Candidate, m.DeltaPct, unit, p, verdict)
+	}
+	return b.String()
+}
+
+// Compare compares candidate against baseline. Throughput, latency and the
+// error rate are tested with a Mann-Whitney U test over the per-second
+// samples of the reports' time series.
+func Compare(baseline, candidate *report.Report, th Thresholds) Result {
+	var res Result
+	add := func(name string, base, cand float64, baseSamples, candSamples []float64, higherIsBetter bool, threshold float64) {
+		m := Metric{Name: name, Baseline: base, Candidate: cand, PValue: math.NaN()}
+		m.DeltaPct = percentChange(base, cand)
+		if len(baseSamples) >= minSamples && len(candSamples) >= minSamples {
+			_, m.PValue = MannWhitney(baseSamples, candSamples)
+			m.Significant = m.PValue < th.Alpha
+		}
+		worse := m.DeltaPct
+		if higherIsBetter {
+			worse = -worse
+		}
+		if threshold > 0 && worse > threshold && (m.Significant || math.IsNaN(m.PValue)) {
+			m.Regression = true
+		}
+		res.Metrics = append(res.Metrics, m)
+	}
+
+	bs, cs := fullSeconds(baseline.Series), fullSeconds(candidate.Series)
+	bl, cl := baseline.Latency.Uncorrected, candidate.Latency.Uncorrected
+	add("avg_response_tps", baseline.TPS.AverageResponse, candidate.TPS.AverageResponse,
+		sample(bs, func(s tracking.Second) float64 { return float64(s.Responses) }),
+		sample(cs, func(s tracking.Second) float64 { return float64(s.Responses) }),
+		true, th.TPSDrop)
+	add("p50_ms", bl.MedianLatency, cl.MedianLatency,
+		sample(bs, func(s tracking.Second) float64 { return s.P50Ms }),
+		sample(cs, func(s tracking.Second) float64 { return s.P50Ms }),
+		false, th.P50Increase)
+	add("p99_ms", bl.P99Latency, cl.P99Latency,
+		sample(bs, func(s tracking.Second) float64 { return s.P99Ms }),
+		sample(cs, func(s tracking.Second) float64 { return s.P99Ms }),
+		false, th.P99Increase)
+	add("max_ms", bl.MaxLatency, cl.MaxLatency,
+		sample(bs, func(s tracking.Second) float64 { return s.MaxMs }),
+		sample(cs, func(s tracking.Second) float64 { return s.MaxMs }),
+		false, th.MaxIncrease)
+
+	// The error rate is compared in percentage points, not relative to the baseline.
+	be, ce := errorRate(baseline), errorRate(candidate)
+	m := Metric{Name: "error_rate_pct", Baseline: be, Candidate: ce, DeltaPct: ce - be, PValue: math.NaN()}
+	bErr := sample(bs, secondErrorRate)
+	cErr := sample(cs, secondErrorRate)
+	if len(bErr) >= minSamples && len(cErr) >= minSamples {
+		_, m.PValue = MannWhitney(bErr, cErr)
+		m.Significant = m.PValue < th.Alpha
+	}
+	if th.ErrorRateIncrease > 0 && m.DeltaPct > th.ErrorRateIncrease && (m.Significant || math.IsNaN(m.PValue)) {
+		m.Regression = true
+	}
+	res.Metrics = append(res.Metrics, m)
+	return res
+}
+
+// minSamples is the smallest number of per-second samples per run that is
+// tested for significance.
+const minSamples = 3
+
+// MannWhitney returns the U statistic of a and the two-sided p-value of the
+// Mann-Whitney U test for the samples a and b, using the normal
+// approximation with tie and continuity correction. Both are NaN if a sample
+// is empty.
+func MannWhitney(a, b []float64) (u, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Assign average ranks to ties and collect the tie correction term.
+	var rankSumA, tieTerm float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // ranks are 1-based
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		i = j
+	}
+
+	n := n1 + n2
+	u = rankSumA - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	// Continuity correction towards the mean.
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return u, 2 * (1 - distuv.UnitNormal.CDF(z))
+}
+
+func percentChange(base, cand float64) float64 {
+	if base == 0 {
+		if cand == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (cand - base) / base * 100
+}
+
+func errorRate(r *report.Report) float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.TotalErrors()) / float64(r.Sent) * 100
+}
+
+func secondErrorRate(s tracking.Second) float64 {
+	if s.Requests+s.Errors == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests+s.Errors) * 100
+}
+
+// fullSeconds keeps the full measure phase buckets. Partial buckets would skew
+// rates and warm-up and cool-down buckets are not part of the measurement.
+func fullSeconds(series []tracking.Second) []tracking.Second {
+	full := make([]tracking.Second, 0, len(series))
+	for _, s := range series {
+		if !s.Partial && s.Measured() {
+			full = append(full, s)
+		}
+	}
+	return full
+}
+
+func sample(series []tracking.Second, value func(tracking.Second) float64) []float64 {
+	values := make([]float64, 0, len(series))
+	for _, s := range series {
+		values = append(values, value(s))
+	}
+	return values
+}
@@ -0,0 +1,90 @@
+package compare
+
+import (
+	"math"
+	"testing"
+
+	"grpc-benchmark-study/internal/report"
+	"grpc-benchmark-study/internal/tracking"
+)
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		u, p float64
+	}{
+		{"separated", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0, 0.012185780355344818},
+		{"reversed", []float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 25, 0.012185780355344818},
+		{"overlapping", []float64{19, 22, 16, 29, 24}, []float64{20, 11, 17, 12}, 17, 0.11134688653314048},
+		{"ties", []float64{1, 2, 2, 3}, []float64{2, 3, 3, 4}, 3, 0.17203370892182296},
+		{"tie across samples", []float64{1.1, 2.2, 3.3}, []float64{3.3, 4.4, 5.5}, 0.5, 0.12118327283746322},
+		{"single values", []float64{1}, []float64{2}, 0, 1},
+		{"all tied", []float64{5, 5, 5}, []float64{5, 5, 5}, 4.5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, p := MannWhitney(tt.a, tt.b)
+			if u != tt.u {
+				t.Errorf("U = %v, want %v", u, tt.u)
+			}
+			if math.Abs(p-tt.p) > 1e-9 {
+				t.Errorf("p = %v, want %v", p, tt.p)
+			}
+		})
+	}
+}
+
+func TestMannWhitneyEmpty(t *testing.T) {
+	u, p := MannWhitney(nil, []float64{1, 2})
+	if !math.IsNaN(u) || !math.IsNaN(p) {
+		t.Fatalf("MannWhitney(nil, b) = %v, %v, want NaN, NaN", u, p)
+	}
+}
+
+// withErrors returns a report with one full second per entry of errors, each
+// second handling 100 calls of which that many failed.
+func withErrors(errors ...int64) *report.Report {
+	r := &report.Report{Errors: map[string]int64{}}
+	for i, e := range errors {
+		r.Series = append(r.Series, tracking.Second{Second: i, Requests: 100 - e, Errors: e})
+		r.Sent += 100 - e
+		r.Errors["unavailable"] += e
+	}
+	return r
+}
+
+func errorRateMetric(t *testing.T, res Result) Metric {
+	t.Helper()
+	for _, m := range res.Metrics {
+		if m.Name == "error_rate_pct" {
+			return m
+		}
+	}
+	t.Fatal("no error_rate_pct metric")
+	return Metric{}
+}
+
+func TestCompareErrorRateNeedsSignificance(t *testing.T) {
+	th := Thresholds{Alpha: 0.05, ErrorRateIncrease: 1}
+	tests := []struct {
+		name       string
+		base, cand *report.Report
+		regression bool
+	}{
+		{"significant rise", withErrors(0, 1, 2, 1, 0, 1), withErrors(10, 11, 12, 11, 10, 11), true},
+		{"noisy rise", withErrors(0, 20, 0, 20, 0, 20), withErrors(20, 0, 20, 0, 20, 20), false},
+		{"too few samples", withErrors(0, 1), withErrors(10, 11), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := errorRateMetric(t, Compare(tt.base, tt.cand, th))
+			if m.DeltaPct <= th.ErrorRateIncrease {
+				t.Fatalf("delta = %.2fpp, want above the threshold", m.DeltaPct)
+			}
+			if m.Regression != tt.regression {
+				t.Fatalf("regression = %v (p = %v), want %v", m.Regression, m.PValue, tt.regression)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// AckOptions configure at-least-once delivery.
+type AckOptions struct {
+	// Timeout is how long a result may stay unacknowledged before it is sent
+	// again, 0 to disable acknowledgements.
+	Timeout time.Duration
+	// MaxRedeliveries is how often a result is sent again before it is given
+	// up.
+	MaxRedeliveries int
+}
+
+// due reports whether a result sent at sentAt and redelivered attempts times
+// is to be sent again at now, or given up.
+func (o AckOptions) due(now, sentAt time.Time, attempts int) (redeliver, giveUp bool) {
+	if o.Timeout <= 0 || now.Sub(sentAt) < o.Timeout {
+		return false, false
+	}
+	if attempts >= o.MaxRedeliveries {
+		return false, true
+	}
+	return true, false
+}
+
+// Tick is how often results are checked for redelivery.
+func (o AckOptions) Tick() time.Duration {
+	return max(o.Timeout/4, time.Millisecond)
+}
+
+// unacked is a result awaiting its acknowledgement.
+type unacked struct {
+	msg      *pb.CalcMessage
+	sentAt   time.Time
+	attempts int
+}
+
+// Unacked numbers the results sent on one bidirectional stream and keeps them
+// until they are acknowledged, so that they can be sent again after a timeout.
+type Unacked struct {
+	opts AckOptions
+
+	mu      sync.Mutex
+	last    uint64
+	pending map[uint64]*unacked
+}
+
+// NewUnacked creates an empty Unacked.
+func NewUnacked(opts AckOptions) *Unacked {
+	return &Unacked{opts: opts, pending: make(map[uint64]*unacked)}
+}
+
+// Add numbers msg and keeps it as sent at now.
+func (u *Unacked) Add(now time.Time, msg *pb.CalcMessage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.last++
+	msg.Seq = u.last
+	u.pending[msg.Seq] = &unacked{msg: msg, sentAt: now}
+}
+
+// Ack drops the result with sequence number seq and reports whether it was
+// still awaiting its acknowledgement.
+func (u *Unacked) Ack(seq uint64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.pending[seq]
+	delete(u.pending, seq)
+	return ok
+}
+
+// Due returns the results to send again at now, oldest first, and counts
+// them as sent. Results out of redeliveries are dropped and counted in
+// gaveUp.
+func (u *Unacked) Due(now time.Time) (msgs []*pb.CalcMessage, gaveUp int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for seq, p := range u.pending {
+		redeliver, giveUp := u.opts.due(now, p.sentAt, p.attempts)
+		switch {
+		case giveUp:
+			delete(u.pending, seq)
+			gaveUp++
+		case redeliver:
+			p.sentAt = now
+			p.attempts++
+			msgs = append(msgs, p.msg)
+		}
+	}
+	slices.SortFunc(msgs, func(a, b *pb.CalcMessage) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return msgs, gaveUp
+}
+
+// Len returns the number of results awaiting their acknowledgement.
+func (u *Unacked) Len() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.pending)
+}
@@ -0,0 +1,85 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// Cluster is a Backend for several server processes acting as one. A result
+// whose subscriber is not connected to this process is forwarded to every
+// peer, and the peer holding the subscription delivers it.
+type Cluster struct {
+	local *Local
+	peers []pb.ClusterServiceClient
+}
+
+// NewCluster creates a Backend that delivers to local subscribers first and
+// forwards everything else to peers.
+func NewCluster(local *Local, peers []pb.ClusterServiceClient) *Cluster {
+	return &Cluster{local: local, peers: peers}
+}
+
+// Subscribe registers a local subscriber for clientID.
+func (c *Cluster) Subscribe(clientID string) (*Subscription, error) {
+	return c.local.Subscribe(clientID)
+}
+
+// Deliver hands msg to the local subscriber of clientID or, if there is none,
+// asks all peers in parallel to deliver it to theirs. It only fails with
+// ErrNoSubscriber if every peer answered that it has no subscriber either.
+func (c *Cluster) Deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, error) {
+	n, _, err := c.deliver(ctx, clientID, msg)
+	return n, err
+}
+
+// deliver is Deliver that also reports whether a peer delivered msg.
+func (c *Cluster) deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, bool, error) {
+	n, err := c.local.Deliver(ctx, clientID, msg)
+	if !errors.Is(err, ErrNoSubscriber) || len(c.peers) == 0 {
+		return n, false, err
+	}
+
+	req := &pb.DeliverRequest{ClientId: clientID, Message: msg}
+	var (
+		mu        sync.Mutex
+		delivered bool
+		dropped   int
+		errs      []error
+		wg        sync.WaitGroup
+	)
+	for i, peer := range c.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := peer.Deliver(ctx, req)
+			mu.Lock()
+			defer mu.Unlock()
+			if status.Code(err) == codes.ResourceExhausted {
+				// The peer's subscriber rejected the result.
+				err = ErrFull
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("peer %d: %w", i, err))
+				return
+			}
+			delivered = delivered || resp.GetDelivered()
+			dropped += int(resp.GetDropped())
+		}()
+	}
+	wg.Wait()
+
+	if delivered {
+		return dropped, true, nil
+	}
+	if len(errs) > 0 {
+		return 0, false, errors.Join(errs...)
+	}
+	return 0, false, ErrNoSubscriber
+}
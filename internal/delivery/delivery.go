@@ -0,0 +1,348 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// ErrNoSubscriber is returned by Deliver when no subscriber for the client
+// could be found.
+var ErrNoSubscriber = errors.New("no subscriber")
+
+// ErrDuplicate is returned by Subscribe when the client already has a
+// subscriber and duplicates are rejected.
+var ErrDuplicate = errors.New("client already subscribed")
+
+// ErrTakenOver is the reason a subscription ended when a newer subscription
+// for the same client took over.
+var ErrTakenOver = errors.New("subscription taken over")
+
+// ErrFull is returned by Deliver when the subscriber's buffer is full and its
+// overflow policy is Reject.
+var ErrFull = errors.New("subscriber buffer full")
+
+// Backend routes the results of PerformCalculationTo to the
+// PerformCalculationFrom subscription of their client.
+type Backend interface {
+	// Subscribe registers a subscriber for clientID.
+	Subscribe(clientID string) (*Subscription, error)
+	// Deliver hands msg to the subscriber of clientID and returns the number
+	// of results the subscriber's overflow policy lost because of it.
+	Deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (dropped int, err error)
+}
+
+// Subscription receives the results of one client. Results arrive on C until
+// Done is closed.
+type Subscription struct {
+	C    <-chan *pb.CalcMessage
+	Done <-chan struct{}
+
+	cancel func()
+	sub    *subscriber
+}
+
+// Cancel ends the subscription and closes Done.
+func (s *Subscription) Cancel() {
+	s.cancel()
+}
+
+// Dropped returns the number of results the subscription lost to its
+// overflow policy so far.
+func (s *Subscription) Dropped() int64 {
+	return s.sub.dropped.Load()
+}
+
+// Err returns why the subscription ended: ErrTakenOver if a newer
+// subscription replaced it, nil if it was cancelled or is still running.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.Done:
+		return s.sub.reason
+	default:
+		return nil
+	}
+}
+
+// Overflow is what a subscriber does with a result that does not fit into its
+// buffer.
+type Overflow string
+
+const (
+	// DropNewest drops the result that does not fit.
+	DropNewest Overflow = "drop-newest"
+	// DropOldest drops the oldest buffered result to make room.
+	DropOldest Overflow = "drop-oldest"
+	// Block waits up to Options.BlockTimeout for room, then drops the result.
+	Block Overflow = "block"
+	// Reject fails the delivery with ErrFull.
+	Reject Overflow = "reject"
+)
+
+// Overflows lists the valid overflow policies.
+var Overflows = []Overflow{DropNewest, DropOldest, Block, Reject}
+
+// ParseOverflow parses the name of an overflow policy.
+func ParseOverflow(s string) (Overflow, error) {
+	for _, o := range Overflows {
+		if string(o) == s {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("invalid overflow policy %q, expected one of %v", s, Overflows)
+}
+
+// Duplicates is what a Local backend does when a client subscribes while it
+// already has a subscriber.
+type Duplicates string
+
+const (
+	// RejectDuplicates fails the new subscription with ErrDuplicate.
+	RejectDuplicates Duplicates = "reject"
+	// Takeover ends the old subscription with ErrTakenOver.
+	Takeover Duplicates = "takeover"
+	// Fanout delivers every result to all subscribers.
+	Fanout Duplicates = "fanout"
+	// Loadshare delivers every result to one subscriber, round-robin,
+	// preferring subscribers with room in their buffer.
+	Loadshare Duplicates = "loadshare"
+)
+
+// DuplicateModes lists the valid duplicate subscriber modes.
+var DuplicateModes = []Duplicates{RejectDuplicates, Takeover, Fanout, Loadshare}
+
+// ParseDuplicates parses the name of a duplicate subscriber mode.
+func ParseDuplicates(s string) (Duplicates, error) {
+	for _, d := range DuplicateModes {
+		if string(d) == s {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("invalid duplicate subscriber mode %q, expected one of %v", s, DuplicateModes)
+}
+
+// Options configure the subscribers of a Local backend.
+type Options struct {
+	// Buffer is the number of results a subscriber can fall behind by.
+	Buffer int
+	// Overflow is the policy for results that do not fit into the buffer.
+	Overflow Overflow
+	// BlockTimeout is how long the Block policy waits for room.
+	BlockTimeout time.Duration
+	// Duplicates is the mode for clients with more than one subscriber.
+	Duplicates Duplicates
+}
+
+// DefaultOptions drop new results once a subscriber is 10 results behind and
+// let a new subscriber take over from the old one.
+var DefaultOptions = Options{Buffer: 10, Overflow: DropNewest, Duplicates: Takeover}
+
+// subscriber is the delivery side of a Subscription.
+type subscriber struct {
+	ch      chan *pb.CalcMessage
+	done    chan struct{}
+	dropped atomic.Int64
+	// reason is set before done is closed.
+	reason error
+}
+
+// full reports whether the subscriber's buffer has no room.
+func (sub *subscriber) full() bool {
+	return len(sub.ch) == cap(sub.ch)
+}
+
+// takeOver moves the results buffered for old, which sub replaces, to sub.
+// Those that do not fit count as dropped by sub.
+func (sub *subscriber) takeOver(old *subscriber) {
+	for {
+		select {
+		case msg := <-old.ch:
+			select {
+			case sub.ch <- msg:
+			default:
+				sub.dropped.Add(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// client holds the subscribers of one clientId.
+type client struct {
+	subs []*subscriber
+	// next is the subscriber the next result goes to in Loadshare mode.
+	next int
+}
+
+// Local is a Backend that only knows the subscribers of this process.
+type Local struct {
+	opts Options
+
+	// mu protects access to the clients map.
+	mu sync.Mutex
+	// clients maps a clientId to its subscribers.
+	clients map[string]*client
+}
+
+// NewLocal creates an empty in-memory Backend whose subscribers follow opts.
+func NewLocal(opts Options) *Local {
+	return &Local{opts: opts, clients: make(map[string]*client)}
+}
+
+// Subscribe registers a subscriber for clientID. If the client already has
+// one, the Duplicates mode decides what happens.
+func (l *Local) Subscribe(clientID string) (*Subscription, error) {
+	sub := &subscriber{
+		ch:   make(chan *pb.CalcMessage, l.opts.Buffer),
+		done: make(chan struct{}),
+	}
+	l.mu.Lock()
+	c, ok := l.clients[clientID]
+	if !ok {
+		c = &client{}
+		l.clients[clientID] = c
+	}
+	if len(c.subs) > 0 {
+		switch l.opts.Duplicates {
+		case RejectDuplicates:
+			l.mu.Unlock()
+			return nil, ErrDuplicate
+		case Fanout, Loadshare:
+		default:
+			for _, old := range c.subs {
+				sub.takeOver(old)
+				old.reason = ErrTakenOver
+				close(old.done)
+			}
+			c.subs = nil
+		}
+	}
+	c.subs = append(c.subs, sub)
+	l.mu.Unlock()
+
+	cancel := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// A takeover has already removed and closed the subscriber.
+		i := slices.Index(c.subs, sub)
+		if i < 0 {
+			return
+		}
+		c.subs = slices.Delete(c.subs, i, i+1)
+		if len(c.subs) == 0 && l.clients[clientID] == c {
+			delete(l.clients, clientID)
+		}
+		close(sub.done)
+	}
+	return &Subscription{C: sub.ch, Done: sub.done, cancel: sync.OnceFunc(cancel), sub: sub}, nil
+}
+
+// Deliver hands msg to the subscribers of clientID the Duplicates mode
+// selects, applying the overflow policy to those whose buffer is full. With
+// Fanout a result only counts as rejected if every subscriber rejected it.
+func (l *Local) Deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, error) {
+	l.mu.Lock()
+	c, ok := l.clients[clientID]
+	if !ok || len(c.subs) == 0 {
+		l.mu.Unlock()
+		return 0, ErrNoSubscriber
+	}
+	var targets []*subscriber
+	if l.opts.Duplicates == Fanout {
+		targets = slices.Clone(c.subs)
+	} else {
+		targets = []*subscriber{l.pick(c)}
+	}
+	l.mu.Unlock()
+
+	var (
+		dropped  int
+		accepted bool
+		lastErr  error
+	)
+	for _, sub := range targets {
+		n, err := l.deliver(ctx, sub, msg)
+		dropped += n
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		accepted = true
+	}
+	if !accepted {
+		return dropped, lastErr
+	}
+	return dropped, nil
+}
+
+// pick returns the subscriber of c that gets the next result. c must have at
+// least one subscriber and l.mu must be held.
+func (l *Local) pick(c *client) *subscriber {
+	if l.opts.Duplicates != Loadshare {
+		return c.subs[0]
+	}
+	n := len(c.subs)
+	start := c.next % n
+	c.next = start + 1
+	for i := 0; i < n; i++ {
+		if sub := c.subs[(start+i)%n]; !sub.full() {
+			c.next = start + i + 1
+			return sub
+		}
+	}
+	return c.subs[start]
+}
+
+// deliver hands msg to sub, applying the overflow policy if its buffer is
+// full.
+func (l *Local) deliver(ctx context.Context, sub *subscriber, msg *pb.CalcMessage) (int, error) {
+	select {
+	case sub.ch <- msg:
+		return 0, nil
+	default:
+	}
+
+	switch l.opts.Overflow {
+	case DropOldest:
+		if cap(sub.ch) == 0 {
+			// There is no older result to make room by.
+			break
+		}
+		dropped := 0
+		for {
+			select {
+			case sub.ch <- msg:
+				sub.dropped.Add(int64(dropped))
+				return dropped, nil
+			default:
+			}
+			select {
+			case <-sub.ch:
+				dropped++
+			default:
+			}
+		}
+	case Block:
+		timer := time.NewTimer(l.opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case sub.ch <- msg:
+			return 0, nil
+		case <-sub.done:
+			return 0, ErrNoSubscriber
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+	case Reject:
+		return 0, ErrFull
+	}
+	sub.dropped.Add(1)
+	return 1, nil
+}
@@ -0,0 +1,470 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+func newTestLocal(duplicates Duplicates) *Local {
+	opts := DefaultOptions
+	opts.Duplicates = duplicates
+	return NewLocal(opts)
+}
+
+func message(s string) *pb.CalcMessage {
+	return &pb.CalcMessage{Payload: []byte(s)}
+}
+
+// received drains the results buffered for sub.
+func received(sub *Subscription) []string {
+	var got []string
+	for {
+		select {
+		case msg := <-sub.C:
+			got = append(got, string(msg.GetPayload()))
+		default:
+			return got
+		}
+	}
+}
+
+func closed(sub *Subscription) bool {
+	select {
+	case <-sub.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+func mustSubscribe(t *testing.T, l *Local, clientID string) *Subscription {
+	t.Helper()
+	sub, err := l.Subscribe(clientID)
+	if err != nil {
+		t.Fatalf("Subscribe(%q) failed: %v", clientID, err)
+	}
+	return sub
+}
+
+func mustDeliver(t *testing.T, l *Local, clientID string, msgs ...string) {
+	t.Helper()
+	for _, m := range msgs {
+		if _, err := l.Deliver(context.Background(), clientID, message(m)); err != nil {
+			t.Fatalf("Deliver(%q, %q) failed: %v", clientID, m, err)
+		}
+	}
+}
+
+func TestRejectDuplicates(t *testing.T) {
+	l := newTestLocal(RejectDuplicates)
+	first := mustSubscribe(t, l, "c1")
+	if _, err := l.Subscribe("c1"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("second Subscribe: got %v, want ErrDuplicate", err)
+	}
+	mustSubscribe(t, l, "c2")
+
+	mustDeliver(t, l, "c1", "a")
+	if got := received(first); len(got) != 1 || got[0] != "a" {
+		t.Errorf("first subscriber got %v, want [a]", got)
+	}
+
+	// Once the first subscriber is gone, the client may subscribe again.
+	first.Cancel()
+	second := mustSubscribe(t, l, "c1")
+	mustDeliver(t, l, "c1", "b")
+	if got := received(second); len(got) != 1 || got[0] != "b" {
+		t.Errorf("second subscriber got %v, want [b]", got)
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	l := newTestLocal(Takeover)
+	first := mustSubscribe(t, l, "c1")
+	second := mustSubscribe(t, l, "c1")
+
+	if !closed(first) {
+		t.Fatal("first subscription still open after takeover")
+	}
+	if !errors.Is(first.Err(), ErrTakenOver) {
+		t.Errorf("first.Err() = %v, want ErrTakenOver", first.Err())
+	}
+	if closed(second) || second.Err() != nil {
+		t.Fatal("second subscription closed by takeover")
+	}
+
+	// The old stream's cleanup must not remove the new subscriber.
+	first.Cancel()
+	mustDeliver(t, l, "c1", "a")
+	if got := received(second); len(got) != 1 || got[0] != "a" {
+		t.Errorf("second subscriber got %v, want [a]", got)
+	}
+
+	second.Cancel()
+	if second.Err() != nil {
+		t.Errorf("second.Err() after Cancel = %v, want nil", second.Err())
+	}
+	if _, err := l.Deliver(context.Background(), "c1", message("b")); !errors.Is(err, ErrNoSubscriber) {
+		t.Errorf("Deliver after Cancel: got %v, want ErrNoSubscriber", err)
+	}
+}
+
+func TestTakeoverMovesBufferedResults(t *testing.T) {
+	opts := DefaultOptions
+	opts.Buffer = 3
+	l := NewLocal(opts)
+	first := mustSubscribe(t, l, "c1")
+	mustDeliver(t, l, "c1", "a", "b", "c")
+	if n, _ := l.Deliver(context.Background(), "c1", message("d")); n != 1 {
+		t.Fatalf("Deliver into a full buffer dropped %d results, want 1", n)
+	}
+
+	second := mustSubscribe(t, l, "c1")
+	if got := received(first); len(got) != 0 {
+		t.Errorf("taken over subscription still buffers %v", got)
+	}
+	if got := received(second); len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Errorf("second subscriber got %v, want [a b c]", got)
+	}
+	if second.Dropped() != 0 {
+		t.Errorf("second.Dropped() = %d, want 0", second.Dropped())
+	}
+
+}
+
+func TestFanout(t *testing.T) {
+	l := newTestLocal(Fanout)
+	subs := []*Subscription{
+		mustSubscribe(t, l, "c1"),
+		mustSubscribe(t, l, "c1"),
+		mustSubscribe(t, l, "c1"),
+	}
+	mustDeliver(t, l, "c1", "a", "b")
+	for i, sub := range subs {
+		if got := received(sub); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+			t.Errorf("subscriber %d got %v, want [a b]", i, got)
+		}
+	}
+
+	subs[1].Cancel()
+	mustDeliver(t, l, "c1", "c")
+	for _, i := range []int{0, 2} {
+		if got := received(subs[i]); len(got) != 1 || got[0] != "c" {
+			t.Errorf("subscriber %d got %v, want [c]", i, got)
+		}
+	}
+}
+
+func TestFanoutRejectsOnlyIfAllRejected(t *testing.T) {
+	l := NewLocal(Options{Buffer: 1, Overflow: Reject, Duplicates: Fanout})
+	full := mustSubscribe(t, l, "c1")
+	mustDeliver(t, l, "c1", "a")
+	open := mustSubscribe(t, l, "c1")
+
+	mustDeliver(t, l, "c1", "b")
+	if got := received(open); len(got) != 1 || got[0] != "b" {
+		t.Errorf("open subscriber got %v, want [b]", got)
+	}
+	mustDeliver(t, l, "c1", "c")
+	if _, err := l.Deliver(context.Background(), "c1", message("d")); !errors.Is(err, ErrFull) {
+		t.Errorf("Deliver to full subscribers: got %v, want ErrFull", err)
+	}
+	if got := received(full); len(got) != 1 || got[0] != "a" {
+		t.Errorf("full subscriber got %v, want [a]", got)
+	}
+}
+
+func TestLoadshare(t *testing.T) {
+	l := newTestLocal(Loadshare)
+	subs := []*Subscription{
+		mustSubscribe(t, l, "c1"),
+		mustSubscribe(t, l, "c1"),
+	}
+	mustDeliver(t, l, "c1", "a", "b", "c", "d")
+	counts := []int{len(received(subs[0])), len(received(subs[1]))}
+	if counts[0] != 2 || counts[1] != 2 {
+		t.Errorf("results per subscriber = %v, want [2 2]", counts)
+	}
+
+	// A subscriber that leaves no longer gets a share.
+	subs[0].Cancel()
+	mustDeliver(t, l, "c1", "e", "f")
+	if got := received(subs[1]); len(got) != 2 {
+		t.Errorf("remaining subscriber got %v, want 2 results", got)
+	}
+}
+
+func TestLoadsharePrefersRoom(t *testing.T) {
+	l := NewLocal(Options{Buffer: 1, Overflow: DropNewest, Duplicates: Loadshare})
+	subs := []*Subscription{
+		mustSubscribe(t, l, "c1"),
+		mustSubscribe(t, l, "c1"),
+	}
+	for _, m := range []string{"a", "b"} {
+		if dropped, err := l.Deliver(context.Background(), "c1", message(m)); err != nil || dropped != 0 {
+			t.Fatalf("Deliver(%q) = %d, %v; want 0, nil", m, dropped, err)
+		}
+	}
+	if dropped, _ := l.Deliver(context.Background(), "c1", message("c")); dropped != 1 {
+		t.Errorf("Deliver to full subscribers dropped %d, want 1", dropped)
+	}
+	if total := subs[0].Dropped() + subs[1].Dropped(); total != 1 {
+		t.Errorf("subscribers dropped %d in total, want 1", total)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		overflow Overflow
+		dropped  int
+		err      error
+		want     []string
+	}{
+		{DropNewest, 1, nil, []string{"a", "b"}},
+		{DropOldest, 1, nil, []string{"b", "c"}},
+		{Block, 1, nil, []string{"a", "b"}},
+		{Reject, 0, ErrFull, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.overflow), func(t *testing.T) {
+			l := NewLocal(Options{Buffer: 2, Overflow: tt.overflow, Duplicates: Takeover})
+			sub := mustSubscribe(t, l, "c1")
+			mustDeliver(t, l, "c1", "a", "b")
+			dropped, err := l.Deliver(context.Background(), "c1", message("c"))
+			if dropped != tt.dropped || !errors.Is(err, tt.err) {
+				t.Errorf("Deliver to full buffer = %d, %v; want %d, %v", dropped, err, tt.dropped, tt.err)
+			}
+			if got := received(sub); len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				t.Errorf("subscriber got %v, want %v", got, tt.want)
+			}
+			if sub.Dropped() != int64(tt.dropped) {
+				t.Errorf("Dropped() = %d, want %d", sub.Dropped(), tt.dropped)
+			}
+		})
+	}
+}
+
+func TestUnackedRedelivery(t *testing.T) {
+	u := NewUnacked(AckOptions{Timeout: time.Second, MaxRedeliveries: 1})
+	start := time.Now()
+	a, b := message("a"), message("b")
+	u.Add(start, a)
+	u.Add(start, b)
+	if a.Seq != 1 || b.Seq != 2 {
+		t.Fatalf("sequence numbers = %d, %d; want 1, 2", a.Seq, b.Seq)
+	}
+	if !u.Ack(a.Seq) || u.Ack(a.Seq) {
+		t.Error("Ack should only report the first acknowledgement")
+	}
+
+	if msgs, _ := u.Due(start.Add(time.Second / 2)); len(msgs) != 0 {
+		t.Errorf("Due before the timeout returned %d results", len(msgs))
+	}
+	msgs, gaveUp := u.Due(start.Add(time.Second))
+	if len(msgs) != 1 || msgs[0] != b || gaveUp != 0 {
+		t.Errorf("Due after the timeout = %v, %d; want [b], 0", msgs, gaveUp)
+	}
+	msgs, gaveUp = u.Due(start.Add(2 * time.Second))
+	if len(msgs) != 0 || gaveUp != 1 || u.Len() != 0 {
+		t.Errorf("Due after the last redelivery = %v, %d with %d left; want none, 1, 0", msgs, gaveUp, u.Len())
+	}
+}
+
+func TestMailboxReplayAndAck(t *testing.T) {
+	opts := MailboxOptions{Size: 2, Dir: t.TempDir()}
+	m, err := NewMailbox(newTestLocal(Takeover), opts)
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if _, err := m.Deliver(context.Background(), "c1", message(s)); !errors.Is(err, ErrKept) {
+			t.Fatalf("Deliver(%q) without subscriber: got %v, want ErrKept", s, err)
+		}
+	}
+	if err := m.Ack("c1", []uint64{3}); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	m.Close()
+
+	// A restarted server replays what was kept, evicted and acknowledged
+	// results aside, and continues the numbering.
+	m, err = NewMailbox(newTestLocal(Takeover), opts)
+	if err != nil {
+		t.Fatalf("reopening mailbox failed: %v", err)
+	}
+	defer m.Close()
+	replay := m.Replay("c1", 0)
+	if len(replay) != 1 || replay[0].Seq != 2 || string(replay[0].Payload) != "b" {
+		t.Errorf("Replay = %v, want only b with seq 2", replay)
+	}
+	sub := mustSubscribe(t, m.backend.(*Local), "c1")
+	msg := message("d")
+	if _, err := m.Deliver(context.Background(), "c1", msg); err != nil || msg.Seq != 4 {
+		t.Errorf("Deliver after restart = seq %d, %v; want 4, nil", msg.Seq, err)
+	}
+	if got := received(sub); len(got) != 1 || got[0] != "d" {
+		t.Errorf("subscriber got %v, want [d]", got)
+	}
+}
+
+func TestMailboxRedeliveryNeedsSubscriber(t *testing.T) {
+	m, err := NewMailbox(newTestLocal(Takeover), MailboxOptions{Size: 10, Acks: AckOptions{Timeout: time.Second, MaxRedeliveries: 1}})
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := m.Deliver(ctx, "c1", message("a")); !errors.Is(err, ErrKept) {
+		t.Fatalf("Deliver without subscriber: got %v, want ErrKept", err)
+	}
+	gaveUp := 0
+	count := func(_ string, n int) { gaveUp += n }
+
+	// However long the client stays away, nothing is handed over, so
+	// nothing counts as a redelivery.
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		m.redeliver(ctx, start.Add(time.Duration(i)*time.Second), count)
+	}
+	if gaveUp != 0 {
+		t.Fatalf("gave up on %d results without a subscriber", gaveUp)
+	}
+
+	sub := mustSubscribe(t, m.backend.(*Local), "c1")
+	m.redeliver(ctx, start.Add(6*time.Second), count)
+	if got := received(sub); len(got) != 1 || got[0] != "a" || gaveUp != 0 {
+		t.Errorf("redelivery to subscriber = %v with %d given up; want [a], 0", got, gaveUp)
+	}
+	m.redeliver(ctx, start.Add(7*time.Second), count)
+	if got := received(sub); len(got) != 0 || gaveUp != 1 {
+		t.Errorf("after the last redelivery = %v with %d given up; want none, 1", got, gaveUp)
+	}
+}
+
+func TestMailboxSweepsEmptyBoxes(t *testing.T) {
+	m, err := NewMailbox(newTestLocal(Takeover), MailboxOptions{Size: 10})
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	ctx := context.Background()
+	m.Deliver(ctx, "c1", message("a"))
+	m.Deliver(ctx, "c2", message("b"))
+	if err := m.Ack("c1", []uint64{1}); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	m.mu.Lock()
+	m.sweep(time.Now())
+	n := len(m.boxes)
+	m.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d mailboxes after the sweep, want 1", n)
+	}
+
+	// A mailbox created again continues the numbering.
+	msg := message("c")
+	if _, err := m.Deliver(ctx, "c1", msg); !errors.Is(err, ErrKept) || msg.Seq != 3 {
+		t.Errorf("Deliver after the sweep = seq %d, %v; want 3, ErrKept", msg.Seq, err)
+	}
+}
+
+func TestMailboxLoadBoundsPayloadLength(t *testing.T) {
+	dir := t.TempDir()
+	data := appendRecord(nil, recordResult, kept{seq: 1, payload: []byte("a")})
+	// A corrupt last record claiming a 4 GiB payload is ignored like a
+	// truncated one, without allocating for it.
+	data = appendRecord(data, recordResult, kept{seq: 2})
+	copy(data[len(data)-4:], []byte{0xff, 0xff, 0xff, 0xff})
+	if err := os.WriteFile(filepath.Join(dir, "c1"+mailboxExt), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMailbox(newTestLocal(Takeover), MailboxOptions{Size: 10, Dir: dir})
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	defer m.Close()
+	if replay := m.Replay("c1", 0); len(replay) != 1 || string(replay[0].Payload) != "a" {
+		t.Errorf("Replay = %v, want only a", replay)
+	}
+}
+
+// adoptingPeer stands in for the ClusterService of another server, which
+// adopts forwarded results into its mailbox.
+type adoptingPeer struct {
+	pb.ClusterServiceClient
+	mailbox *Mailbox
+}
+
+func (p adoptingPeer) Deliver(ctx context.Context, req *pb.DeliverRequest, _ ...grpc.CallOption) (*pb.DeliverResponse, error) {
+	dropped, err := p.mailbox.Adopt(ctx, req.GetClientId(), req.GetMessage())
+	if errors.Is(err, ErrNoSubscriber) {
+		return &pb.DeliverResponse{Delivered: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DeliverResponse{Delivered: true, Dropped: uint32(dropped)}, nil
+}
+
+func TestMailboxAckOfForwardedResult(t *testing.T) {
+	opts := MailboxOptions{Size: 10, Acks: AckOptions{Timeout: time.Second, MaxRedeliveries: 3}}
+	localB := newTestLocal(Takeover)
+	b, err := NewMailbox(NewCluster(localB, nil), opts)
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	localA := newTestLocal(Takeover)
+	a, err := NewMailbox(NewCluster(localA, []pb.ClusterServiceClient{adoptingPeer{mailbox: b}}), opts)
+	if err != nil {
+		t.Fatalf("NewMailbox failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// A client that is away on both servers: a keeps its results, b does
+	// not, but numbered them when a asked it to take them over.
+	for _, s := range []string{"x", "y"} {
+		if _, err := a.Deliver(ctx, "c2", message(s)); !errors.Is(err, ErrKept) {
+			t.Fatalf("Deliver(%q) without subscriber: got %v, want ErrKept", s, err)
+		}
+	}
+	sub := mustSubscribe(t, localB, "c1")
+	if _, err := b.Deliver(ctx, "c1", message("own")); err != nil {
+		t.Fatalf("Deliver on b failed: %v", err)
+	}
+	forwarded := message("forwarded")
+	if _, err := a.Deliver(ctx, "c1", forwarded); err != nil {
+		t.Fatalf("Deliver on a failed: %v", err)
+	}
+	var seqs []uint64
+	for range 2 {
+		seqs = append(seqs, (<-sub.C).Seq)
+	}
+	// a numbered the forwarded result 3, the number b gave its own one.
+	if seqs[0] != 3 || seqs[1] != 4 || forwarded.Seq != 3 {
+		t.Fatalf("subscriber got seqs %v for a's %d, want b's numbers [3 4]", seqs, forwarded.Seq)
+	}
+
+	// The client acknowledges the forwarded result to b, which kept it.
+	if err := b.Ack("c1", seqs[1:]); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if replay := b.Replay("c1", 0); len(replay) != 1 || string(replay[0].Payload) != "own" {
+		t.Errorf("b keeps %v after the ack, want only own", replay)
+	}
+	// a handed the result over and keeps only its other client's results.
+	if replay := a.Replay("c1", 0); len(replay) != 0 {
+		t.Errorf("a still keeps %d forwarded results", len(replay))
+	}
+	if replay := a.Replay("c2", 0); len(replay) != 2 {
+		t.Errorf("a keeps %d results of c2, want 2", len(replay))
+	}
+	a.redeliver(ctx, time.Now().Add(2*time.Second), nil)
+	if got := received(sub); len(got) != 0 {
+		t.Errorf("a redelivered %v after b took the result over", got)
+	}
+}
@@ -0,0 +1,575 @@
+package delivery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "grpc-benchmark-study/protos/grpc-benchmark-study/calculator"
+)
+
+// ErrKept is returned by Mailbox.Deliver when the client has no subscriber
+// and the result was kept for replay.
+var ErrKept = errors.New("no subscriber, kept in mailbox")
+
+// MailboxOptions configure a Mailbox.
+type MailboxOptions struct {
+	// Size is the number of results kept per client; older ones are evicted.
+	Size int
+	// TTL is how long a result is kept, 0 for no limit.
+	TTL time.Duration
+	// Dir, if set, is the directory results are persisted in, one file per
+	// client, so that they survive a server restart.
+	Dir string
+	// Acks, if enabled, keeps results until the client acknowledges them and
+	// sends them again while it does not.
+	Acks AckOptions
+}
+
+// Mailbox is a Backend that numbers the results of every client and keeps
+// the latest of them, so that a reconnecting subscriber can resume after the
+// last result it saw. Results are delivered live through the wrapped Backend.
+type Mailbox struct {
+	backend Backend
+	opts    MailboxOptions
+
+	// seq is the last sequence number handed out. All clients share it, so
+	// that the mailbox of a client can be dropped once empty and created
+	// again without numbering its results anew.
+	seq atomic.Uint64
+
+	// mu protects access to the boxes map and swept.
+	mu    sync.Mutex
+	boxes map[string]*mailbox
+	// swept is the number of boxes left by the last sweep.
+	swept int
+}
+
+// NewMailbox wraps backend with a mailbox per client. With opts.Dir set, the
+// mailboxes persisted there are loaded first.
+func NewMailbox(backend Backend, opts MailboxOptions) (*Mailbox, error) {
+	if opts.Size < 1 {
+		return nil, fmt.Errorf("mailbox size must be positive, got %d", opts.Size)
+	}
+	m := &Mailbox{backend: backend, opts: opts, boxes: make(map[string]*mailbox)}
+	if opts.Dir == "" {
+		return m, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(opts.Dir, "*"+mailboxExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		clientID, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), mailboxExt))
+		if err != nil {
+			continue
+		}
+		box := &mailbox{opts: opts, path: path, seq: &m.seq}
+		if err := box.load(time.Now()); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("loading mailbox of %s: %w", clientID, err)
+		}
+		m.boxes[clientID] = box
+		m.seq.Store(max(m.seq.Load(), box.last))
+	}
+	return m, nil
+}
+
+// Subscribe registers a subscriber for clientID with the wrapped Backend.
+// Results kept earlier are not replayed; use Replay for that.
+func (m *Mailbox) Subscribe(clientID string) (*Subscription, error) {
+	return m.backend.Subscribe(clientID)
+}
+
+// Deliver numbers msg, keeps it in the client's mailbox and hands it to the
+// wrapped Backend. Without a subscriber the result stays in the mailbox and
+// ErrKept is returned. A rejected result is removed again, and so is one a
+// cluster peer delivered: the peer keeps it under its own number.
+func (m *Mailbox) Deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, error) {
+	box, seq, err := m.keep(clientID, msg.GetPayload())
+	if err != nil {
+		return 0, err
+	}
+	msg.Seq = seq
+
+	n, forwarded, err := m.deliver(ctx, clientID, msg)
+	switch {
+	case errors.Is(err, ErrNoSubscriber):
+		return 0, ErrKept
+	case errors.Is(err, ErrFull), err == nil && forwarded:
+		if rerr := box.remove(seq); rerr != nil {
+			return n, errors.Join(err, rerr)
+		}
+	}
+	return n, err
+}
+
+// Adopt keeps msg, a result a cluster peer forwarded, under a sequence number
+// of this mailbox and hands it to the subscriber of clientID on this server
+// only. The client then acknowledges it and resumes after it with this
+// server's numbers. A result without a subscriber here, or rejected by it, is
+// not kept and the error says why, so that the peer keeps it instead.
+func (m *Mailbox) Adopt(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, error) {
+	local := m.backend
+	if c, ok := local.(*Cluster); ok {
+		local = c.local
+	}
+	box, seq, err := m.keep(clientID, msg.GetPayload())
+	if err != nil {
+		return 0, err
+	}
+	n, err := local.Deliver(ctx, clientID, &pb.CalcMessage{Payload: msg.GetPayload(), Seq: seq})
+	if err != nil {
+		if rerr := box.remove(seq); rerr != nil {
+			return n, errors.Join(err, rerr)
+		}
+	}
+	return n, err
+}
+
+// keep stores payload in the mailbox of clientID and returns the mailbox and
+// the sequence number it was kept under.
+func (m *Mailbox) keep(clientID string, payload []byte) (*mailbox, uint64, error) {
+	for {
+		box, err := m.box(clientID)
+		if err != nil {
+			return nil, 0, err
+		}
+		seq, err := box.store(time.Now(), payload)
+		if errors.Is(err, errDropped) {
+			continue // swept in the meantime
+		}
+		return box, seq, err
+	}
+}
+
+// deliver hands msg to the wrapped Backend and reports whether a peer of a
+// Cluster delivered it.
+func (m *Mailbox) deliver(ctx context.Context, clientID string, msg *pb.CalcMessage) (int, bool, error) {
+	if c, ok := m.backend.(*Cluster); ok {
+		return c.deliver(ctx, clientID, msg)
+	}
+	n, err := m.backend.Deliver(ctx, clientID, msg)
+	return n, false, err
+}
+
+// Replay returns the kept results of clientID with a sequence number after
+// seq, oldest first.
+func (m *Mailbox) Replay(clientID string, seq uint64) []*pb.CalcMessage {
+	m.mu.Lock()
+	box, ok := m.boxes[clientID]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return box.after(time.Now(), seq)
+}
+
+// Ack drops the results of clientID with the given sequence numbers, which
+// the client acknowledged.
+func (m *Mailbox) Ack(clientID string, seqs []uint64) error {
+	m.mu.Lock()
+	box, ok := m.boxes[clientID]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, seq := range seqs {
+		errs = append(errs, box.remove(seq))
+	}
+	return errors.Join(errs...)
+}
+
+// Redeliver sends unacknowledged results again until ctx is done and reports
+// the results given up after their last redelivery to gaveUp. It does nothing
+// unless acknowledgements are enabled.
+func (m *Mailbox) Redeliver(ctx context.Context, gaveUp func(clientID string, n int)) {
+	if m.opts.Acks.Timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.opts.Acks.Tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.redeliver(ctx, now, gaveUp)
+		}
+	}
+}
+
+// redeliver sends the results due at now again. Only a result handed to a
+// subscriber counts as a redelivery; without one it waits for the client to
+// resume, for as long as the TTL keeps it.
+func (m *Mailbox) redeliver(ctx context.Context, now time.Time, gaveUp func(clientID string, n int)) {
+	m.mu.Lock()
+	m.sweep(now)
+	boxes := make(map[string]*mailbox, len(m.boxes))
+	for clientID, box := range m.boxes {
+		boxes[clientID] = box
+	}
+	m.mu.Unlock()
+	for clientID, box := range boxes {
+		msgs, n := box.due(now)
+		if n > 0 && gaveUp != nil {
+			gaveUp(clientID, n)
+		}
+		for _, msg := range msgs {
+			_, forwarded, err := m.deliver(ctx, clientID, msg)
+			switch {
+			case err == nil && forwarded:
+				// The peer keeps it now. A failed write is caught up by the
+				// next compaction.
+				box.remove(msg.Seq)
+			case err == nil:
+				box.sent(now, msg.Seq)
+			}
+		}
+	}
+}
+
+// sweep drops the mailboxes that keep no result, so that clients that went
+// away do not pile up. Their files stay, so that a restart continues the
+// numbering. m.mu must be held.
+func (m *Mailbox) sweep(now time.Time) {
+	for clientID, box := range m.boxes {
+		if box.drop(now) {
+			delete(m.boxes, clientID)
+		}
+	}
+	m.swept = len(m.boxes)
+}
+
+// Close closes the files of all mailboxes.
+func (m *Mailbox) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, box := range m.boxes {
+		box.mu.Lock()
+		errs = append(errs, box.close())
+		box.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// box returns the mailbox of clientID, creating it if needed.
+func (m *Mailbox) box(clientID string) (*mailbox, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if box, ok := m.boxes[clientID]; ok {
+		return box, nil
+	}
+	// Sweeping whenever the boxes doubled keeps the cost per new box
+	// constant.
+	if len(m.boxes) >= 2*max(m.swept, 1) {
+		m.sweep(time.Now())
+	}
+	box := &mailbox{opts: m.opts, seq: &m.seq}
+	if m.opts.Dir != "" {
+		box.path = filepath.Join(m.opts.Dir, url.PathEscape(clientID)+mailboxExt)
+		if err := box.open(); err != nil {
+			return nil, err
+		}
+	}
+	m.boxes[clientID] = box
+	return box, nil
+}
+
+// mailboxExt is the file name extension of persisted mailboxes.
+const mailboxExt = ".mbox"
+
+// Record kinds of a persisted mailbox. A file is a sequence of records, each
+// a kind byte, the sequence number, the expiry in Unix nanoseconds, the
+// payload length and the payload. Remove records have no payload.
+const (
+	recordResult byte = 'r'
+	recordRemove byte = 'd'
+)
+
+// recordHeader is the size of a record without its payload.
+const recordHeader = 1 + 8 + 8 + 4
+
+// kept is a result in a mailbox. sentAt and attempts track its
+// redeliveries; they are not persisted.
+type kept struct {
+	seq      uint64
+	expires  time.Time
+	payload  []byte
+	sentAt   time.Time
+	attempts int
+}
+
+// errDropped is returned by mailbox.store for a mailbox that was swept.
+var errDropped = errors.New("mailbox dropped")
+
+// mailbox holds the kept results of one client in sequence order.
+type mailbox struct {
+	opts MailboxOptions
+	path string
+	seq  *atomic.Uint64 // the sequence numbers of the Mailbox
+
+	mu      sync.Mutex
+	last    uint64
+	results []kept
+	file    *os.File
+	// records is the number of records in file.
+	records int
+	dropped bool
+}
+
+// store keeps payload under the next sequence number and returns it.
+func (b *mailbox) store(now time.Time, payload []byte) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dropped {
+		return 0, errDropped
+	}
+	b.prune(now)
+	b.last = b.seq.Add(1)
+	k := kept{seq: b.last, payload: payload, sentAt: now}
+	if b.opts.TTL > 0 {
+		k.expires = now.Add(b.opts.TTL)
+	}
+	b.results = append(b.results, k)
+	if len(b.results) > b.opts.Size {
+		b.results = b.results[len(b.results)-b.opts.Size:]
+	}
+	return k.seq, b.write(recordResult, k)
+}
+
+// remove drops the result with sequence number seq.
+func (b *mailbox) remove(seq uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, k := range b.results {
+		if k.seq == seq {
+			b.results = append(b.results[:i:i], b.results[i+1:]...)
+			return b.write(recordRemove, kept{seq: seq})
+		}
+	}
+	return nil
+}
+
+// after returns the results with a sequence number after seq.
+func (b *mailbox) after(now time.Time, seq uint64) []*pb.CalcMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	var msgs []*pb.CalcMessage
+	for _, k := range b.results {
+		if k.seq > seq {
+			msgs = append(msgs, &pb.CalcMessage{Payload: k.payload, Seq: k.seq})
+		}
+	}
+	return msgs
+}
+
+// due returns the results to send again at now; sent counts them once they
+// are. Results out of redeliveries are dropped and counted in gaveUp.
+func (b *mailbox) due(now time.Time) (msgs []*pb.CalcMessage, gaveUp int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	var keep []kept
+	for _, k := range b.results {
+		redeliver, giveUp := b.opts.Acks.due(now, k.sentAt, k.attempts)
+		switch {
+		case giveUp:
+			gaveUp++
+			// Removals are only persisted to keep a restart from replaying
+			// what was given up; a failed write is caught up by the next
+			// compaction.
+			b.write(recordRemove, kept{seq: k.seq})
+			continue
+		case redeliver:
+			msgs = append(msgs, &pb.CalcMessage{Payload: k.payload, Seq: k.seq})
+		}
+		keep = append(keep, k)
+	}
+	b.results = keep
+	return msgs, gaveUp
+}
+
+// sent counts a redelivery of the result with sequence number seq at now.
+func (b *mailbox) sent(now time.Time, seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.results {
+		if b.results[i].seq == seq {
+			b.results[i].sentAt = now
+			b.results[i].attempts++
+			return
+		}
+	}
+}
+
+// drop closes the mailbox and reports true if it keeps no result at now.
+func (b *mailbox) drop(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	if len(b.results) > 0 {
+		return false
+	}
+	// A failed close loses nothing; the file holds no result.
+	b.close()
+	b.dropped = true
+	return true
+}
+
+// prune drops expired results. Results expire in sequence order.
+func (b *mailbox) prune(now time.Time) {
+	i := 0
+	for i < len(b.results) && !b.results[i].expires.IsZero() && !now.Before(b.results[i].expires) {
+		i++
+	}
+	b.results = b.results[i:]
+}
+
+// write appends a record to the file, if any, and rewrites the file once
+// evicted and removed results make up most of it.
+func (b *mailbox) write(kind byte, k kept) error {
+	if b.file == nil {
+		return nil
+	}
+	if b.records >= 2*b.opts.Size {
+		return b.compact()
+	}
+	if _, err := b.file.Write(appendRecord(nil, kind, k)); err != nil {
+		return err
+	}
+	b.records++
+	return nil
+}
+
+// compact rewrites the file with only the kept results.
+func (b *mailbox) compact() error {
+	var buf []byte
+	for _, k := range b.results {
+		buf = appendRecord(buf, recordResult, k)
+	}
+	if len(b.results) == 0 {
+		// Keep the last sequence number, so that it is not reused.
+		buf = appendRecord(buf, recordRemove, kept{seq: b.last})
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	if err := b.close(); err != nil {
+		return err
+	}
+	if err := b.open(); err != nil {
+		return err
+	}
+	b.records = max(len(b.results), 1)
+	return nil
+}
+
+// load reads the file of the mailbox and compacts it. A truncated last
+// record, left by a crash in the middle of a write, is ignored.
+func (b *mailbox) load(now time.Time) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// left is the number of bytes not read yet, which bounds the payload
+	// length a record may claim.
+	left := info.Size()
+	r := bufio.NewReader(f)
+	header := make([]byte, recordHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		left -= recordHeader
+		k := kept{seq: binary.BigEndian.Uint64(header[1:])}
+		if ns := int64(binary.BigEndian.Uint64(header[9:])); ns != 0 {
+			k.expires = time.Unix(0, ns)
+		}
+		n := int64(binary.BigEndian.Uint32(header[17:]))
+		if n > left {
+			break
+		}
+		left -= n
+		k.payload = make([]byte, n)
+		if _, err := io.ReadFull(r, k.payload); err != nil {
+			break
+		}
+		b.last = max(b.last, k.seq)
+		switch header[0] {
+		case recordResult:
+			// Evict as store did when the record was written.
+			b.results = append(b.results, k)
+			if len(b.results) > b.opts.Size {
+				b.results = b.results[len(b.results)-b.opts.Size:]
+			}
+		case recordRemove:
+			for i := range b.results {
+				if b.results[i].seq == k.seq {
+					b.results = append(b.results[:i:i], b.results[i+1:]...)
+					break
+				}
+			}
+		default:
+			return fmt.Errorf("unknown record kind %q", header[0])
+		}
+	}
+	b.prune(now)
+	return b.compact()
+}
+
+func (b *mailbox) open() error {
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	b.file = f
+	return nil
+}
+
+func (b *mailbox) close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
+
+// appendRecord appends the encoding of a record to buf.
+func appendRecord(buf []byte, kind byte, k kept) []byte {
+	var expires int64
+	if !k.expires.IsZero() {
+		expires = k.expires.UnixNano()
+	}
+	buf = append(buf, kind)
+	buf = binary.BigEndian.AppendUint64(buf, k.seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expires))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(k.payload)))
+	return append(buf, k.payload...)
+}
@@ -0,0 +1,130 @@
+package lb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// LeastRequest is the name of the least-request balancer registered by this
+// package. Every RPC, unary call or stream, goes to the ready backend with the
+// fewest RPCs in flight; ties go to the backend after the last one picked.
+const LeastRequest = "least_request"
+
+// Policies lists the load balancing policies Dial accepts.
+var Policies = []string{"pick_first", "round_robin", LeastRequest}
+
+func init() {
+	balancer.Register(leastRequestBalancerBuilder{})
+}
+
+// leastRequestBalancerBuilder gives every ClientConn its own picker builder,
+// so the in-flight counts live and die with the balancer that owns the
+// SubConns.
+type leastRequestBalancerBuilder struct{}
+
+func (leastRequestBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(LeastRequest, &leastRequestBuilder{
+		inFlight: make(map[balancer.SubConn]*int64),
+	}, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+func (leastRequestBalancerBuilder) Name() string {
+	return LeastRequest
+}
+
+// leastRequestBuilder builds a new picker whenever the set of ready backends
+// changes. In-flight counts are kept per SubConn across pickers, so RPCs that
+// started under an old picker are still counted. The SubConns of the static
+// resolver's addresses are reused across reconnects, so the map stays bounded
+// by the number of backends.
+type leastRequestBuilder struct {
+	mu       sync.Mutex
+	inFlight map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p := &leastRequestPicker{}
+	for sc := range info.ReadySCs {
+		n, ok := b.inFlight[sc]
+		if !ok {
+			n = new(int64)
+			b.inFlight[sc] = n
+		}
+		p.subConns = append(p.subConns, sc)
+		p.inFlight = append(p.inFlight, n)
+	}
+	return p
+}
+
+type leastRequestPicker struct {
+	subConns []balancer.SubConn
+	inFlight []*int64
+	next     atomic.Uint64
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	best := LeastOutstanding(len(p.subConns), &p.next, func(i int) (int64, bool) {
+		return atomic.LoadInt64(p.inFlight[i]), true
+	})
+	counter := p.inFlight[best]
+	atomic.AddInt64(counter, 1)
+	return balancer.PickResult{
+		SubConn: p.subConns[best],
+		Done:    func(balancer.DoneInfo) { atomic.AddInt64(counter, -1) },
+	}, nil
+}
+
+// LeastOutstanding returns the index of the one of n candidates with the
+// fewest outstanding requests, or -1 if none is available. outstanding reports
+// the count of candidate i, or false if it cannot take a request. Every call
+// starts the scan one candidate further along next, so that ties are spread
+// evenly.
+func LeastOutstanding(n int, next *atomic.Uint64, outstanding func(i int) (int64, bool)) int {
+	if n == 0 {
+		return -1
+	}
+	offset := int(next.Add(1) % uint64(n))
+	best, bestCount := -1, int64(0)
+	for j := 0; j < n; j++ {
+		i := (offset + j) % n
+		if count, ok := outstanding(i); ok && (best < 0 || count < bestCount) {
+			best, bestCount = i, count
+		}
+	}
+	return best
+}
+
+// Dial creates one ClientConn balancing over all addrs with policy. The
+// addresses are handed to gRPC by a static resolver.
+func Dial(addrs []string, policy string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	known := false
+	for _, p := range Policies {
+		known = known || p == policy
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown load balancing policy %q, expected one of %s", policy, strings.Join(Policies, ", "))
+	}
+	r := manual.NewBuilderWithScheme("static")
+	state := resolver.State{}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	r.InitialState(state)
+	opts = append(opts,
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, policy)))
+	return grpc.Dial(r.Scheme()+":///backends", opts...)
+}
@@ -0,0 +1,44 @@
+package lb
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestLeastOutstanding(t *testing.T) {
+	tests := []struct {
+		name        string
+		outstanding []int64
+		failed      []bool
+		want        int
+	}{
+		{"fewest", []int64{3, 1, 2}, nil, 1},
+		{"skips unavailable", []int64{3, 1, 2}, []bool{false, true, false}, 2},
+		{"none available", []int64{0, 0}, []bool{true, true}, -1},
+		{"no candidates", nil, nil, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var next atomic.Uint64
+			got := LeastOutstanding(len(tt.outstanding), &next, func(i int) (int64, bool) {
+				return tt.outstanding[i], tt.failed == nil || !tt.failed[i]
+			})
+			if got != tt.want {
+				t.Fatalf("LeastOutstanding = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeastOutstandingSpreadsTies(t *testing.T) {
+	var next atomic.Uint64
+	counts := make([]int, 3)
+	for range 30 {
+		counts[LeastOutstanding(len(counts), &next, func(int) (int64, bool) { return 0, true })]++
+	}
+	for i, c := range counts {
+		if c != 10 {
+			t.Fatalf("candidate %d picked %d times, want 10 (%v)", i, c, counts)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package loadgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slot is a single scheduled transaction. Seq is the transaction index and
+// Intended is the time the load schedule wanted the transaction to be sent.
+// Intended is the zero time for closed-loop (unscheduled) transactions.
+type Slot struct {
+	Seq      int
+	Intended time.Time
+}
+
+// ParseRate parses a target arrival rate such as "500/s", "30000/m" or "500"
+// (per second) and returns it in transactions per second.
+func ParseRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	unit := time.Second
+	if i := strings.Index(s, "/"); i >= 0 {
+		switch strings.ToLower(s[i+1:]) {
+		case "ms":
+			unit = time.Millisecond
+		case "s", "sec":
+			unit = time.Second
+		case "m", "min":
+			unit = time.Minute
+		default:
+			return 0, fmt.Errorf("invalid rate unit in %q", s)
+		}
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %v", s, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("rate must be positive, got %q", s)
+	}
+	return n * float64(time.Second) / float64(unit), nil
+}
+
+// Pacer emits Slots following a load Profile. The intended send time of
+// every slot is derived from the start time and the profile only, so a slow
+// consumer does not slow the schedule down (open-loop load generation).
+type Pacer struct {
+	profile Profile
+}
+
+// NewPacer creates a Pacer for the given constant rate in transactions per second.
+func NewPacer(rate float64) *Pacer {
+	return NewProfilePacer(Constant{Rate: rate})
+}
+
+// NewProfilePacer creates a Pacer whose rate follows profile.
+func NewProfilePacer(profile Profile) *Pacer {
+	return &Pacer{profile: profile}
+}
+
+// Profile returns the load profile of the pacer.
+func (p *Pacer) Profile() Profile {
+	return p.profile
+}
+
+// Run emits slots on the returned channel, each one no earlier than its
+// intended send time, and closes the channel after total slots (0 for no
+// limit) or once either the next intended time or the current time is not
+// before until (zero for no limit). If the consumer falls behind, pending
+// slots keep their original intended time and are handed out as soon as the
+// consumer is ready again, but never after until.
+func (p *Pacer) Run(start time.Time, total int, until time.Time, done <-chan struct{}) <-chan Slot {
+	slots := make(chan Slot)
+	go func() {
+		defer close(slots)
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		// Seconds since start of the next intended send. Each send is followed
+		// by the next one after the inverse of the rate at that moment.
+		var at float64
+		for seq := 0; total == 0 || seq < total; seq++ {
+			intended := start.Add(time.Duration(at * float64(time.Second)))
+			at += 1 / p.profile.RateAt(intended.Sub(start))
+			if !until.IsZero() && !intended.Before(until) {
+				return
+			}
+			if wait := time.Until(intended); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-done:
+					return
+				}
+			}
+			slot := Slot{Seq: seq, Intended: intended}
+			if until.IsZero() {
+				select {
+				case slots <- slot:
+				case <-done:
+					return
+				}
+				continue
+			}
+			deadline := time.Until(until)
+			if deadline <= 0 {
+				return
+			}
+			timer.Reset(deadline)
+			select {
+			case slots <- slot:
+				timer.Stop()
+			case <-timer.C:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return slots
+}
+
+// Sequence emits slots without an intended send time as fast as they are
+// consumed, stopping after total slots (0 for no limit) or at until (zero for
+// no limit). It is the closed-loop counterpart of Pacer.Run.
+func Sequence(total int, until time.Time, done <-chan struct{}) <-chan Slot {
+	slots := make(chan Slot)
+	go func() {
+		defer close(slots)
+		for seq := 0; total == 0 || seq < total; seq++ {
+			if !until.IsZero() && !time.Now().Before(until) {
+				return
+			}
+			select {
+			case slots <- Slot{Seq: seq}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return slots
+}
+
+// Mix is a weighted operation mix. Operations are handed out in a fixed
+// weighted round-robin order, so the same sequence number always maps to the
+// same operation and runs stay reproducible.
+type Mix struct {
+	order []string
+}
+
+// ParseMix parses an operation mix such as "ADD" or "ADD:3,ISPRIME:1".
+// Operations without a weight count once.
+func ParseMix(spec string) (*Mix, error) {
+	var order []string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op, weight := part, 1
+		if i := strings.Index(part, ":"); i >= 0 {
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", part)
+			}
+			op, weight = part[:i], w
+		}
+		for j := 0; j < weight; j++ {
+			order = append(order, op)
+		}
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("empty operation mix %q", spec)
+	}
+	return &Mix{order: order}, nil
+}
+
+// Pick returns the operation of the seq-th transaction.
+func (m *Mix) Pick(seq int) string {
+	return m.order[seq%len(m.order)]
+}
@@ -0,0 +1,219 @@
+package loadgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile describes how the target arrival rate changes over the course of a
+// run. RateAt returns the rate in transactions per second at elapsed time
+// since the start of the run and Length the natural length of the profile, or
+// zero if it goes on forever.
+type Profile interface {
+	RateAt(elapsed time.Duration) float64
+	Length() time.Duration
+	String() string
+}
+
+// Constant holds a single rate.
+type Constant struct {
+	Rate float64
+}
+
+func (c Constant) RateAt(time.Duration) float64 { return c.Rate }
+func (c Constant) Length() time.Duration        { return 0 }
+func (c Constant) String() string               { return fmt.Sprintf("constant %.2f/s", c.Rate) }
+
+// Ramp increases (or decreases) the rate linearly from From to To over Over
+// and holds To afterwards.
+type Ramp struct {
+	From, To float64
+	Over     time.Duration
+}
+
+func (r Ramp) RateAt(elapsed time.Duration) float64 {
+	if elapsed >= r.Over {
+		return r.To
+	}
+	return r.From + (r.To-r.From)*float64(elapsed)/float64(r.Over)
+}
+
+func (r Ramp) Length() time.Duration { return r.Over }
+
+func (r Ramp) String() string {
+	return fmt.Sprintf("ramp %.2f/s to %.2f/s over %s", r.From, r.To, r.Over)
+}
+
+// Steps is a staircase that starts at From and adds Step every Hold, for
+// Count steps in total. The last step is held after the staircase ends.
+type Steps struct {
+	From, Step float64
+	Hold       time.Duration
+	Count      int
+}
+
+func (s Steps) RateAt(elapsed time.Duration) float64 {
+	i := int(elapsed / s.Hold)
+	if i >= s.Count {
+		i = s.Count - 1
+	}
+	return s.From + float64(i)*s.Step
+}
+
+func (s Steps) Length() time.Duration { return s.Hold * time.Duration(s.Count) }
+
+func (s Steps) String() string {
+	return fmt.Sprintf("%d steps from %.2f/s by %.2f/s, each held %s", s.Count, s.From, s.Step, s.Hold)
+}
+
+// Spike holds Base and bursts to Peak for Hold at the end of every Every.
+type Spike struct {
+	Base, Peak  float64
+	Every, Hold time.Duration
+}
+
+func (s Spike) RateAt(elapsed time.Duration) float64 {
+	if elapsed%s.Every >= s.Every-s.Hold {
+		return s.Peak
+	}
+	return s.Base
+}
+
+func (s Spike) Length() time.Duration { return 0 }
+
+func (s Spike) String() string {
+	return fmt.Sprintf("%.2f/s with spikes to %.2f/s for %s every %s", s.Base, s.Peak, s.Hold, s.Every)
+}
+
+// AverageRate returns the average rate of p between from and to, sampled at
+// 100 evenly spaced points.
+func AverageRate(p Profile, from, to time.Duration) float64 {
+	const samples = 100
+	step := (to - from) / samples
+	var sum float64
+	for i := 0; i < samples; i++ {
+		sum += p.RateAt(from + step*time.Duration(i) + step/2)
+	}
+	return sum / samples
+}
+
+// ParseProfile parses a load profile such as
+//
+//	ramp:from=100/s,to=2000/s,over=60s
+//	steps:from=100/s,step=100/s,hold=10s,count=10   (or to=1000/s instead of count)
+//	spike:base=200/s,peak=2000/s,every=10s,hold=1s
+//
+// Rates use the ParseRate syntax and durations the time.ParseDuration one.
+func ParseProfile(spec string) (Profile, error) {
+	kind, params, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if strings.TrimSpace(param) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid profile parameter %q in %q", param, spec)
+		}
+		values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	p := profileParams{spec: spec, values: values}
+
+	var profile Profile
+	switch kind {
+	case "ramp":
+		profile = Ramp{From: p.rate("from"), To: p.rate("to"), Over: p.duration("over")}
+	case "steps":
+		s := Steps{From: p.rate("from"), Step: p.rate("step"), Hold: p.duration("hold")}
+		if _, ok := values["to"]; ok {
+			to := p.rate("to")
+			if p.err == nil {
+				s.Count = int((to-s.From)/s.Step) + 1
+			}
+		} else {
+			s.Count = p.int("count")
+		}
+		if p.err == nil && s.Count < 1 {
+			p.err = fmt.Errorf("steps profile %q has no steps", spec)
+		}
+		profile = s
+	case "spike":
+		s := Spike{Base: p.rate("base"), Peak: p.rate("peak"), Every: p.duration("every"), Hold: p.duration("hold")}
+		if p.err == nil && s.Hold >= s.Every {
+			p.err = fmt.Errorf("spike hold must be shorter than every in %q", spec)
+		}
+		profile = s
+	default:
+		return nil, fmt.Errorf("unknown load profile %q, expected ramp, steps or spike", kind)
+	}
+	for k := range values {
+		if !p.used[k] {
+			return nil, fmt.Errorf("unknown parameter %q in profile %q", k, spec)
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return profile, nil
+}
+
+// profileParams reads the parameters of a profile spec, keeping the first error.
+type profileParams struct {
+	spec   string
+	values map[string]string
+	used   map[string]bool
+	err    error
+}
+
+func (p *profileParams) get(key string) (string, bool) {
+	if p.used == nil {
+		p.used = map[string]bool{}
+	}
+	p.used[key] = true
+	v, ok := p.values[key]
+	if !ok && p.err == nil {
+		p.err = fmt.Errorf("profile %q is missing %s", p.spec, key)
+	}
+	return v, ok && p.err == nil
+}
+
+func (p *profileParams) rate(key string) float64 {
+	v, ok := p.get(key)
+	if !ok {
+		return 0
+	}
+	r, err := ParseRate(v)
+	if err != nil {
+		p.err = fmt.Errorf("profile %q: %s: %v", p.spec, key, err)
+	}
+	return r
+}
+
+func (p *profileParams) duration(key string) time.Duration {
+	v, ok := p.get(key)
+	if !ok {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	if err != nil {
+		p.err = fmt.Errorf("profile %q: %s: %v", p.spec, key, err)
+	}
+	return d
+}
+
+func (p *profileParams) int(key string) int {
+	v, ok := p.get(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		p.err = fmt.Errorf("profile %q: %s: %v", p.spec, key, err)
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package loadgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Profile
+	}{
+		{"ramp:from=100/s,to=2000/s,over=60s", Ramp{From: 100, To: 2000, Over: time.Minute}},
+		{" ramp: from=6000/m , to=2/ms, over=1m ", Ramp{From: 100, To: 2000, Over: time.Minute}},
+		{"steps:from=100/s,step=100/s,hold=10s,count=10", Steps{From: 100, Step: 100, Hold: 10 * time.Second, Count: 10}},
+		{"steps:from=100/s,step=100/s,hold=10s,to=1000/s", Steps{From: 100, Step: 100, Hold: 10 * time.Second, Count: 10}},
+		{"spike:base=200/s,peak=2000/s,every=10s,hold=1s", Spike{Base: 200, Peak: 2000, Every: 10 * time.Second, Hold: time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseProfile(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseProfile failed: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseProfile = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProfileMalformed(t *testing.T) {
+	tests := []struct {
+		name, spec, err string
+	}{
+		{"empty", "", "unknown load profile"},
+		{"unknown kind", "sawtooth:from=1/s", "unknown load profile"},
+		{"missing parameter", "ramp:from=100/s,to=200/s", "missing over"},
+		{"parameter without value", "ramp:from=100/s,to=200/s,over", "invalid profile parameter"},
+		{"unknown parameter", "ramp:from=100/s,to=200/s,over=10s,by=5/s", `unknown parameter "by"`},
+		{"bad rate", "ramp:from=fast,to=200/s,over=10s", "from: invalid rate"},
+		{"bad rate unit", "ramp:from=100/h,to=200/s,over=10s", "invalid rate unit"},
+		{"negative rate", "ramp:from=-5/s,to=200/s,over=10s", "rate must be positive"},
+		{"bad duration", "ramp:from=100/s,to=200/s,over=soon", "over:"},
+		{"zero duration", "ramp:from=100/s,to=200/s,over=0s", "must be positive"},
+		{"bad count", "steps:from=100/s,step=100/s,hold=10s,count=ten", "count:"},
+		{"zero count", "steps:from=100/s,step=100/s,hold=10s,count=0", "no steps"},
+		{"to below from", "steps:from=500/s,step=100/s,hold=10s,to=100/s", "no steps"},
+		{"spike as long as its period", "spike:base=200/s,peak=2000/s,every=1s,hold=1s", "shorter than every"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseProfile(tt.spec)
+			if err == nil {
+				t.Fatalf("ParseProfile(%q) = %v, want an error", tt.spec, p)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("ParseProfile(%q) error = %q, want it to contain %q", tt.spec, err, tt.err)
+			}
+		})
+	}
+}
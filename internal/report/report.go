@@ -0,0 +1,472 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"grpc-benchmark-study/internal/tracking"
+)
+
+// Duration is a time.Duration that is written to JSON as a string such as
+// "30s" and read back from either such a string or a number of nanoseconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(data, &ns); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(ns)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// RunConfig describes the parameters a benchmark run was executed with.
+// Operation is a single operation or a weighted mix such as "ADD:3,ISPRIME:1".
+// A run stops after Transactions sends or after Duration, whichever comes
+// first; a zero value disables that limit. Samples taken during Warmup and
+// the final Cooldown of Duration are left out of the statistics. Profile, if
+// set, is a load profile that replaces the constant Rate; every step of it is
+// checked against SLO. Unary calls are spread over Conns connections
+// according to ConnPolicy. Streams bidirectional streams are spread over Conns
+// connections and sends over the streams according to StreamPolicy. With
+// several Endpoints every connection balances its calls over them according
+// to LBPolicy. Batch mode sends BatchSize calculations per stream. Reconnect
+// says how broken streams are reopened. In unary mode a result that has not
+// arrived after ResultTimeout is given up as lost. Codec names the codec of the
+// calculations in the signed payloads. Every calculation carries PayloadBytes
+// of Padding ("random" or "compressible"), which the server echoes, or
+// replaces with ResponseBytes of padding if set. MaxRecvMsgSize and
+// MaxSendMsgSize are the client's message size limits, 0 for gRPC's
+// defaults.
+type RunConfig struct {
+	Name           string    `json:"name,omitempty"`
+	Repetition     int       `json:"repetition,omitempty"`
+	Mode           string    `json:"mode"`
+	ClientID       string    `json:"client_id"`
+	Workers        int       `json:"workers"`
+	Streams        int       `json:"streams,omitempty"`
+	Conns          int       `json:"conns,omitempty"`
+	ConnPolicy     string    `json:"conn_policy,omitempty"`
+	StreamPolicy   string    `json:"stream_policy,omitempty"`
+	BatchSize      int       `json:"batch_size,omitempty"`
+	Endpoints      []string  `json:"endpoints,omitempty"`
+	LBPolicy       string    `json:"lb_policy,omitempty"`
+	Acks           bool      `json:"acks,omitempty"`
+	Reconnect      Reconnect `json:"reconnect"`
+	ResultTimeout  Duration  `json:"result_timeout,omitempty"`
+	IntervalMs     int       `json:"interval_ms"`
+	Rate           float64   `json:"rate"` // target transactions per second, 0 for closed-loop
+	Profile        string    `json:"profile,omitempty"`
+	Transactions   int       `json:"transactions"`
+	Duration       Duration  `json:"duration"`
+	Warmup         Duration  `json:"warmup"`
+	Cooldown       Duration  `json:"cooldown"`
+	Operation      string    `json:"operation"`
+	JWTGen         string    `json:"jwt_gen"`
+	Codec          string    `json:"codec,omitempty"`
+	PayloadBytes   int       `json:"payload_bytes,omitempty"`
+	Padding        string    `json:"padding,omitempty"`
+	ResponseBytes  int       `json:"response_bytes,omitempty"`
+	MaxRecvMsgSize int       `json:"max_recv_msg_size,omitempty"`
+	MaxSendMsgSize int       `json:"max_send_msg_size,omitempty"`
+	X              int       `json:"x"`
+	Y              int       `json:"y"`
+	SLO            SLO       `json:"slo"`
+}
+
+// Reconnect is the policy for streams that break during a run. A broken
+// stream is reopened after Backoff, doubled after every failed attempt up to
+// MaxBackoff. The transactions it left unanswered are then sent again
+// ("resend"), given up ("lost") or left waiting for a replay ("keep"),
+// according to Unanswered.
+type Reconnect struct {
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+	Unanswered string   `json:"unanswered"`
+}
+
+// Report is the self-contained result of one benchmark run. Sent, Received,
+// Errors, TPS and Latency cover the measure phase; Phases also counts the
+// entries of the warm-up and cool-down phases.
+type Report struct {
+	Config          RunConfig                              `json:"config"`
+	StartedAt       time.Time                              `json:"started_at"`
+	DurationSeconds float64                                `json:"duration_s"`
+	Sent            int64                                  `json:"sent"`
+	Received        int64                                  `json:"received"`
+	Duplicates      int64                                  `json:"duplicates,omitempty"` // responses for IDs already answered
+	Lost            int64                                  `json:"lost,omitempty"`       // unanswered after a reconnect and given up
+	Phases          map[tracking.Phase]tracking.PhaseCount `json:"phases,omitempty"`
+	Errors          map[string]int64                       `json:"errors"`
+	TPS             tracking.TPSStats                      `json:"tps"`
+	Latency         tracking.LatencyReport                 `json:"latency"`
+	Series          []tracking.Second                      `json:"series"`
+	Saturation      *Saturation                            `json:"saturation,omitempty"`
+	Breakdown       []tracking.LabelStats                  `json:"breakdown,omitempty"` // per stream or connection
+	Reconnects      []tracking.Reconnect                   `json:"reconnects,omitempty"`
+	DowntimeSeconds float64                                `json:"downtime_s,omitempty"` // summed over all reconnects
+}
+
+// TotalErrors returns the sum of all error counts.
+func (r *Report) TotalErrors() int64 {
+	var total int64
+	for _, n := range r.Errors {
+		total += n
+	}
+	return total
+}
+
+// WriteJSON writes the report as indented JSON to path.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadJSON loads a report previously written with WriteJSON. It also accepts
+// the file of a Suite with a single run, and rejects one with more.
+func ReadJSON(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Report
+		Runs []*Report `json:"runs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	switch {
+	case file.Runs == nil:
+		return &file.Report, nil
+	case len(file.Runs) == 1:
+		return file.Runs[0], nil
+	default:
+		return nil, fmt.Errorf("%s: a suite of %d runs, not a single run report", path, len(file.Runs))
+	}
+}
+
+// WriteCSV writes the report to path as CSV. Run level values come first as
+// "section,field,value" rows, followed by the per-second time series whose
+// rows start with "series" and whose first row is the column header.
+func (r *Report) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(r.csvRows()); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *Report) csvRows() [][]string {
+	c := r.Config
+	rows := [][]string{
+		{"section", "field", "value"},
+		{"config", "name", c.Name},
+		{"config", "repetition", strconv.Itoa(c.Repetition)},
+		{"config", "mode", c.Mode},
+		{"config", "client_id", c.ClientID},
+		{"config", "workers", strconv.Itoa(c.Workers)},
+		{"config", "streams", strconv.Itoa(c.Streams)},
+		{"config", "conns", strconv.Itoa(c.Conns)},
+		{"config", "conn_policy", c.ConnPolicy},
+		{"config", "stream_policy", c.StreamPolicy},
+		{"config", "batch_size", strconv.Itoa(c.BatchSize)},
+		{"config", "endpoints", strings.Join(c.Endpoints, " ")},
+		{"config", "lb_policy", c.LBPolicy},
+		{"config", "acks", strconv.FormatBool(c.Acks)},
+		{"config", "reconnect_backoff", time.Duration(c.Reconnect.Backoff).String()},
+		{"config", "reconnect_max_backoff", time.Duration(c.Reconnect.MaxBackoff).String()},
+		{"config", "unanswered", c.Reconnect.Unanswered},
+		{"config", "result_timeout", time.Duration(c.ResultTimeout).String()},
+		{"config", "interval_ms", strconv.Itoa(c.IntervalMs)},
+		{"config", "rate", formatFloat(c.Rate)},
+		{"config", "profile", c.Profile},
+		{"config", "transactions", strconv.Itoa(c.Transactions)},
+		{"config", "duration", time.Duration(c.Duration).String()},
+		{"config", "warmup", time.Duration(c.Warmup).String()},
+		{"config", "cooldown", time.Duration(c.Cooldown).String()},
+		{"config", "operation", c.Operation},
+		{"config", "jwt_gen", c.JWTGen},
+		{"config", "codec", c.Codec},
+		{"config", "payload_bytes", strconv.Itoa(c.PayloadBytes)},
+		{"config", "padding", c.Padding},
+		{"config", "response_bytes", strconv.Itoa(c.ResponseBytes)},
+		{"config", "max_recv_msg_size", strconv.Itoa(c.MaxRecvMsgSize)},
+		{"config", "max_send_msg_size", strconv.Itoa(c.MaxSendMsgSize)},
+		{"config", "x", strconv.Itoa(c.X)},
+		{"config", "y", strconv.Itoa(c.Y)},
+		{"config", "slo_p99_ms", formatFloat(c.SLO.P99Ms)},
+		{"config", "slo_error_rate_pct", formatFloat(c.SLO.ErrorRatePct)},
+		{"summary", "started_at", r.StartedAt.Format(time.RFC3339Nano)},
+		{"summary", "duration_s", formatFloat(r.DurationSeconds)},
+		{"summary", "sent", strconv.FormatInt(r.Sent, 10)},
+		{"summary", "received", strconv.FormatInt(r.Received, 10)},
+		{"summary", "duplicates", strconv.FormatInt(r.Duplicates, 10)},
+		{"summary", "lost", strconv.FormatInt(r.Lost, 10)},
+		{"summary", "reconnects", strconv.Itoa(len(r.Reconnects))},
+		{"summary", "downtime_s", formatFloat(r.DowntimeSeconds)},
+		{"tps", "avg_request_tps", formatFloat(r.TPS.AverageRequest)},
+		{"tps", "max_request_tps", strconv.FormatInt(r.TPS.MaxRequest, 10)},
+		{"tps", "avg_response_tps", formatFloat(r.TPS.AverageResponse)},
+		{"tps", "max_response_tps", strconv.FormatInt(r.TPS.MaxResponse, 10)},
+	}
+
+	for _, p := range []tracking.Phase{tracking.PhaseWarmup, tracking.PhaseMeasure, tracking.PhaseCooldown} {
+		if c, ok := r.Phases[p]; ok {
+			rows = append(rows,
+				[]string{"phases", string(p) + "_sent", strconv.FormatInt(c.Sent, 10)},
+				[]string{"phases", string(p) + "_received", strconv.FormatInt(c.Received, 10)})
+		}
+	}
+
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		rows = append(rows, []string{"errors", kind, strconv.FormatInt(r.Errors[kind], 10)})
+	}
+
+	rows = append(rows, latencyRows("latency_uncorrected", r.Latency.Uncorrected)...)
+	rows = append(rows, latencyRows("latency_corrected", r.Latency.Corrected)...)
+
+	if len(r.Breakdown) > 0 {
+		rows = append(rows, []string{"breakdown", "label", "sent", "received", "avg_ms", "p50_ms", "p90_ms", "p99_ms", "max_ms"})
+		for _, b := range r.Breakdown {
+			rows = append(rows, []string{"breakdown",
+				b.Label,
+				strconv.FormatInt(b.Sent, 10),
+				strconv.FormatInt(b.Received, 10),
+				formatFloat(b.Latency.AverageLatency),
+				formatFloat(b.Latency.MedianLatency),
+				formatFloat(b.Latency.P90Latency),
+				formatFloat(b.Latency.P99Latency),
+				formatFloat(b.Latency.MaxLatency),
+			})
+		}
+	}
+
+	if len(r.Reconnects) > 0 {
+		rows = append(rows, []string{"reconnect", "stream", "at", "downtime_ms", "attempts", "resent", "lost", "error"})
+		for _, rc := range r.Reconnects {
+			rows = append(rows, []string{"reconnect",
+				rc.Stream,
+				rc.At.Format(time.RFC3339Nano),
+				formatFloat(rc.DowntimeMs),
+				strconv.Itoa(rc.Attempts),
+				strconv.Itoa(rc.Resent),
+				strconv.Itoa(rc.Lost),
+				rc.Error,
+			})
+		}
+	}
+
+	if sat := r.Saturation; sat != nil {
+		rows = append(rows,
+			[]string{"saturation", "max_sustained_rate", formatFloat(sat.MaxSustained)},
+			[]string{"saturation", "broken_at_rate", formatFloat(sat.BrokenAt)})
+		rows = append(rows, []string{"step", "target_rate", "seconds", "achieved_tps", "p99_ms", "error_rate_pct", "sustained", "reason"})
+		for _, st := range sat.Steps {
+			rows = append(rows, []string{"step",
+				formatFloat(st.Target),
+				strconv.Itoa(st.Seconds),
+				formatFloat(st.Achieved),
+				formatFloat(st.P99Ms),
+				formatFloat(st.ErrorRatePct),
+				strconv.FormatBool(st.Sustained),
+				st.Reason,
+			})
+		}
+	}
+
+	rows = append(rows, []string{"series", "second", "requests", "responses", "errors", "in_flight", "p50_ms", "p90_ms", "p99_ms", "max_ms", "partial", "phase", "target_rate"})
+	for _, s := range r.Series {
+		rows = append(rows, []string{"series",
+			strconv.Itoa(s.Second),
+			strconv.FormatInt(s.Requests, 10),
+			strconv.FormatInt(s.Responses, 10),
+			strconv.FormatInt(s.Errors, 10),
+			strconv.FormatInt(s.InFlight, 10),
+			formatFloat(s.P50Ms),
+			formatFloat(s.P90Ms),
+			formatFloat(s.P99Ms),
+			formatFloat(s.MaxMs),
+			strconv.FormatBool(s.Partial),
+			string(s.Phase),
+			formatFloat(s.Target),
+		})
+	}
+	return rows
+}
+
+func latencyRows(section string, ls tracking.LatencyStats) [][]string {
+	return [][]string{
+		{section, "count", strconv.FormatInt(ls.Count, 10)},
+		{section, "avg_ms", formatFloat(ls.AverageLatency)},
+		{section, "p50_ms", formatFloat(ls.MedianLatency)},
+		{section, "p90_ms", formatFloat(ls.P90Latency)},
+		{section, "p95_ms", formatFloat(ls.P95Latency)},
+		{section, "p99_ms", formatFloat(ls.P99Latency)},
+		{section, "p999_ms", formatFloat(ls.P999Latency)},
+		{section, "p9999_ms", formatFloat(ls.P9999Latency)},
+		{section, "min_ms", formatFloat(ls.MinLatency)},
+		{section, "max_ms", formatFloat(ls.MaxLatency)},
+		{section, "stddev_ms", formatFloat(ls.StdDevLatency)},
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Suite is the combined result of several runs executed in sequence, such as
+// the runs of a scenario file.
+type Suite struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+	Runs      []*Report `json:"runs"`
+}
+
+// WriteJSON writes the suite as indented JSON to path.
+func (s *Suite) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// WriteCSV writes the rows of every run to path, each prefixed with the run's
+// name (or its index when unnamed) and repetition.
+func (s *Suite) WriteCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	for i, r := range s.Runs {
+		name := r.Config.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if r.Config.Repetition > 0 {
+			name += " #" + strconv.Itoa(r.Config.Repetition)
+		}
+		rows := r.csvRows()
+		if i == 0 {
+			// Column header, written once for the whole suite.
+			if err := w.Write(append([]string{"run"}, rows[0]...)); err != nil {
+				return err
+			}
+		}
+		for _, row := range rows[1:] {
+			if err := w.Write(append([]string{name}, row...)); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ComparisonTable renders a markdown table with one row per run name. Runs
+// sharing a name (repetitions of a matrix cell) are combined: counts are
+// summed, rates and latency percentiles are averaged. If any run has a
+// saturation analysis, the highest sustained rate is added as a column.
+func ComparisonTable(runs []*Report) string {
+	type cell struct {
+		name                          string
+		runs                          int
+		sent, received, errors        int64
+		reqTPS, resTPS                float64
+		p50, p90, p99, p999, max, avg float64
+		sustained                     float64
+	}
+	saturation := false
+	var order []string
+	cells := map[string]*cell{}
+	for _, r := range runs {
+		c, ok := cells[r.Config.Name]
+		if !ok {
+			c = &cell{name: r.Config.Name}
+			cells[r.Config.Name] = c
+			order = append(order, r.Config.Name)
+		}
+		lat := r.Latency.Uncorrected
+		c.runs++
+		c.sent += r.Sent
+		c.received += r.Received
+		c.errors += r.TotalErrors()
+		c.reqTPS += r.TPS.AverageRequest
+		c.resTPS += r.TPS.AverageResponse
+		c.avg += lat.AverageLatency
+		c.p50 += lat.MedianLatency
+		c.p90 += lat.P90Latency
+		c.p99 += lat.P99Latency
+		c.p999 += lat.P999Latency
+		c.max += lat.MaxLatency
+		if r.Saturation != nil {
+			saturation = true
+			c.sustained += r.Saturation.MaxSustained
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("| Cell | Runs | Sent | Received | Errors | Avg Req TPS | Avg Resp TPS | Avg (ms) | p50 (ms) | p90 (ms) | p99 (ms) | p99.9 (ms) | Max (ms) |")
+	if saturation {
+		b.WriteString(" Sustained (/s) |")
+	}
+	b.WriteString("\n|------|------|------|----------|--------|-------------|--------------|----------|----------|----------|----------|------------|----------|")
+	if saturation {
+		b.WriteString("----------------|")
+	}
+	b.WriteString("\n")
+	for _, name := range order {
+		c := cells[name]
+		n := float64(c.runs)
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %.2f | %.2f | %.3f | %.3f | %.3f | %.3f | %.3f | %.3f |",
+			c.name, c.runs, c.sent, c.received, c.errors, c.reqTPS/n, c.resTPS/n,
+			c.avg/n, c.p50/n, c.p90/n, c.p99/n, c.p999/n, c.max/n)
+		if saturation {
+			fmt.Fprintf(&b, " %.2f |", c.sustained/n)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"grpc-benchmark-study/internal/tracking"
+)
+
+// sustainedFraction is the share of the target rate a step has to answer to
+// count as sustained. Below it the server is falling behind even if the
+// responses that do arrive are fast.
+const sustainedFraction = 0.95
+
+// SLO is the service level objective a load step must meet to count as
+// sustained. A zero value disables that check.
+type SLO struct {
+	P99Ms        float64 `json:"p99_ms,omitempty"`
+	ErrorRatePct float64 `json:"error_rate_pct,omitempty"`
+}
+
+// Step is one load level of a run: consecutive full seconds of the measure
+// phase with the same target rate. A ramp has a new step every second.
+type Step struct {
+	Target       float64 `json:"target_rate"`
+	Seconds      int     `json:"seconds"`
+	Achieved     float64 `json:"achieved_tps"`   // responses per second
+	P99Ms        float64 `json:"p99_ms"`         // highest per-second p99 of the step
+	ErrorRatePct float64 `json:"error_rate_pct"` // errors per request in percent
+	Sustained    bool    `json:"sustained"`
+	Reason       string  `json:"reason,omitempty"` // why the step was not sustained
+}
+
+// Saturation is the result of checking every load step of a run against an
+// SLO. MaxSustained is the highest target rate sustained before the first
+// step that broke the SLO, BrokenAt the target rate of that step (0 if none).
+type Saturation struct {
+	SLO          SLO     `json:"slo"`
+	Steps        []Step  `json:"steps"`
+	MaxSustained float64 `json:"max_sustained_rate"`
+	BrokenAt     float64 `json:"broken_at_rate,omitempty"`
+}
+
+// Saturate groups the measured, full seconds of series that have a target
+// rate into steps and checks each step against slo.
+func Saturate(series []tracking.Second, slo SLO) *Saturation {
+	sat := &Saturation{SLO: slo}
+	var step *Step
+	var requests, responses, errors int64
+	flush := func() {
+		if step == nil {
+			return
+		}
+		step.Achieved = float64(responses) / float64(step.Seconds)
+		if requests+errors > 0 {
+			step.ErrorRatePct = float64(errors) / float64(requests+errors) * 100
+		}
+		var reasons []string
+		if step.Achieved < step.Target*sustainedFraction {
+			reasons = append(reasons, fmt.Sprintf("throughput %.2f/s below %.0f%% of target", step.Achieved, sustainedFraction*100))
+		}
+		if slo.P99Ms > 0 && step.P99Ms > slo.P99Ms {
+			reasons = append(reasons, fmt.Sprintf("p99 %.3fms > %.3fms", step.P99Ms, slo.P99Ms))
+		}
+		if slo.ErrorRatePct > 0 && step.ErrorRatePct > slo.ErrorRatePct {
+			reasons = append(reasons, fmt.Sprintf("error rate %.2f%% > %.2f%%", step.ErrorRatePct, slo.ErrorRatePct))
+		}
+		step.Sustained = len(reasons) == 0
+		step.Reason = strings.Join(reasons, ", ")
+		sat.Steps = append(sat.Steps, *step)
+		step = nil
+		requests, responses, errors = 0, 0, 0
+	}
+
+	for _, s := range series {
+		if s.Partial || !s.Measured() || s.Target <= 0 {
+			continue
+		}
+		if step != nil && math.Abs(s.Target-step.Target) > step.Target*0.005 {
+			flush()
+		}
+		if step == nil {
+			step = &Step{Target: s.Target}
+		}
+		step.Seconds++
+		requests += s.Requests
+		responses += s.Responses
+		errors += s.Errors
+		if s.P99Ms > step.P99Ms {
+			step.P99Ms = s.P99Ms
+		}
+	}
+	flush()
+
+	for _, st := range sat.Steps {
+		if !st.Sustained {
+			sat.BrokenAt = st.Target
+			break
+		}
+		if st.Target > sat.MaxSustained {
+			sat.MaxSustained = st.Target
+		}
+	}
+	return sat
+}
+
+// String renders the steps as a table followed by the saturation point.
+func (s *Saturation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Saturation (SLO p99 <= %s, error rate <= %s):\n", limit(s.SLO.P99Ms, "ms"), limit(s.SLO.ErrorRatePct, "%"))
+	fmt.Fprintf(&b, "  %12s %8s %14s %10s %10s  %s\n", "Target (/s)", "Seconds", "Achieved (/s)", "p99 (ms)", "Errors (%)", "Verdict")
+	for _, st := range s.Steps {
+		verdict := "ok"
+		if !st.Sustained {
+			verdict = "BROKEN: " + st.Reason
+		}
+		fmt.Fprintf(&b, "  %12.2f %8d %14.2f %10.3f %10.2f  %s\n",
+			st.Target, st.Seconds, st.Achieved, st.P99Ms, st.ErrorRatePct, verdict)
+	}
+	switch {
+	case len(s.Steps) == 0:
+		b.WriteString("  No full seconds with a target rate were measured")
+	case s.BrokenAt == 0:
+		fmt.Fprintf(&b, "  Every step was sustained, highest rate %.2f/s", s.MaxSustained)
+	default:
+		fmt.Fprintf(&b, "  Highest sustained rate: %.2f/s, SLO broken at %.2f/s", s.MaxSustained, s.BrokenAt)
+	}
+	return b.String()
+}
+
+func limit(v float64, unit string) string {
+	if v <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%g%s", v, unit)
+}
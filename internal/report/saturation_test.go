@@ -0,0 +1,129 @@
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"grpc-benchmark-study/internal/tracking"
+)
+
+// seconds returns n full measured seconds at target that answer responses
+// each, with errors failed requests and the given p99.
+func seconds(n int, target float64, responses, errors int64, p99 float64) []tracking.Second {
+	var series []tracking.Second
+	for range n {
+		series = append(series, tracking.Second{Target: target, Requests: responses, Responses: responses, Errors: errors, P99Ms: p99})
+	}
+	return series
+}
+
+func series(parts ...[]tracking.Second) []tracking.Second {
+	var all []tracking.Second
+	for _, p := range parts {
+		all = append(all, p...)
+	}
+	for i := range all {
+		all[i].Second = i
+	}
+	return all
+}
+
+func TestSaturate(t *testing.T) {
+	slo := SLO{P99Ms: 10, ErrorRatePct: 1}
+	tests := []struct {
+		name         string
+		series       []tracking.Second
+		steps        int
+		maxSustained float64
+		brokenAt     float64
+		reason       string
+	}{
+		{
+			name:         "every step sustained",
+			series:       series(seconds(3, 100, 100, 0, 5), seconds(3, 200, 200, 0, 8)),
+			steps:        2,
+			maxSustained: 200,
+		},
+		{
+			name:         "p99 knee",
+			series:       series(seconds(3, 100, 100, 0, 5), seconds(3, 200, 200, 0, 8), seconds(3, 300, 300, 0, 25)),
+			steps:        3,
+			maxSustained: 200,
+			brokenAt:     300,
+			reason:       "p99 25.000ms > 10.000ms",
+		},
+		{
+			name:         "throughput knee",
+			series:       series(seconds(2, 100, 100, 0, 1), seconds(2, 200, 180, 0, 1)),
+			steps:        2,
+			maxSustained: 100,
+			brokenAt:     200,
+			reason:       "throughput 180.00/s below 95% of target",
+		},
+		{
+			name:         "error rate knee",
+			series:       series(seconds(2, 100, 100, 0, 1), seconds(2, 200, 196, 4, 1)),
+			steps:        2,
+			maxSustained: 100,
+			brokenAt:     200,
+			reason:       "error rate 2.00% > 1.00%",
+		},
+		{
+			name:         "first step broken",
+			series:       series(seconds(2, 100, 50, 0, 1)),
+			steps:        1,
+			maxSustained: 0,
+			brokenAt:     100,
+		},
+		{
+			name:         "recovery after the knee does not count",
+			series:       series(seconds(2, 100, 100, 0, 1), seconds(2, 200, 200, 0, 50), seconds(2, 300, 300, 0, 1)),
+			steps:        3,
+			maxSustained: 100,
+			brokenAt:     200,
+		},
+		{
+			name:         "targets within half a percent are one step",
+			series:       series(seconds(2, 1000, 1000, 0, 1), seconds(2, 1004, 1004, 0, 1)),
+			steps:        1,
+			maxSustained: 1000,
+		},
+		{
+			name:   "no target rate",
+			series: series(seconds(3, 0, 100, 0, 1)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sat := Saturate(tt.series, slo)
+			if len(sat.Steps) != tt.steps {
+				t.Fatalf("steps = %d, want %d: %+v", len(sat.Steps), tt.steps, sat.Steps)
+			}
+			if sat.MaxSustained != tt.maxSustained || sat.BrokenAt != tt.brokenAt {
+				t.Fatalf("max sustained = %v, broken at = %v, want %v and %v", sat.MaxSustained, sat.BrokenAt, tt.maxSustained, tt.brokenAt)
+			}
+			if tt.reason == "" {
+				return
+			}
+			for _, st := range sat.Steps {
+				if st.Target == tt.brokenAt && !strings.Contains(st.Reason, tt.reason) {
+					t.Fatalf("reason = %q, want it to contain %q", st.Reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestSaturateSkipsUnmeasuredSeconds(t *testing.T) {
+	s := series(seconds(4, 100, 100, 0, 1))
+	// A slow warm-up second, a failing partial one and a failing cool-down
+	// one must not break the step.
+	s[0].Phase, s[0].P99Ms = tracking.PhaseWarmup, 100
+	s[1].Partial, s[1].Responses = true, 0
+	s[3].Phase, s[3].Errors = tracking.PhaseCooldown, 100
+
+	sat := Saturate(s, SLO{P99Ms: 10, ErrorRatePct: 1})
+	if len(sat.Steps) != 1 || sat.Steps[0].Seconds != 1 || !sat.Steps[0].Sustained {
+		t.Fatalf("steps = %+v, want one sustained step of one second", sat.Steps)
+	}
+}
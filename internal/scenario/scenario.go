@@ -0,0 +1,308 @@
+package scenario
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"grpc-benchmark-study/internal/loadgen"
+	"grpc-benchmark-study/internal/report"
+)
+
+// Run is one benchmark run as written in a scenario file. Rate, Duration,
+// Warmup and Cooldown are strings such as "500/s" and "30s". Operations is a
+// weighted operation mix and, when set, replaces Operation. Profile is a load
+// profile in loadgen.ParseProfile syntax. Unanswered is the policy for the
+// transactions a broken stream left unanswered. PayloadBytes and
+// ResponseBytes size the padding of the calculations and their results.
+type Run struct {
+	Name         string         `yaml:"name"`
+	Mode         string         `yaml:"mode"`
+	ClientID     string         `yaml:"client_id"`
+	Workers      int            `yaml:"workers"`
+	Streams      int            `yaml:"streams"`
+	Conns        int            `yaml:"conns"`
+	ConnPolicy   string         `yaml:"conn_policy"`
+	StreamPolicy string         `yaml:"stream_policy"`
+	BatchSize    int            `yaml:"batch_size"`
+	Acks         bool           `yaml:"acks"`
+	Unanswered   string         `yaml:"unanswered"`
+	IntervalMs   int            `yaml:"interval_ms"`
+	Rate         string         `yaml:"rate"`
+	Profile      string         `yaml:"profile"`
+	Transactions int            `yaml:"transactions"`
+	Duration     string         `yaml:"duration"`
+	Warmup       string         `yaml:"warmup"`
+	Cooldown     string         `yaml:"cooldown"`
+	Operation    string         `yaml:"operation"`
+	Operations   map[string]int `yaml:"operations"`
+	JWTGen       string         `yaml:"jwt_gen"`
+	Codec        string         `yaml:"codec"`
+	// Payload is the deprecated name of Codec, used when codec is not set.
+	Payload       string  `yaml:"payload"`
+	PayloadBytes  int     `yaml:"payload_bytes"`
+	Padding       string  `yaml:"padding"`
+	ResponseBytes int     `yaml:"response_bytes"`
+	X             int     `yaml:"x"`
+	Y             int     `yaml:"y"`
+	SLOP99Ms      float64 `yaml:"slo_p99_ms"`
+	SLOErrorRate  float64 `yaml:"slo_error_rate_pct"`
+}
+
+// Scenario is a named list of runs that are executed in sequence, with an
+// optional pause between them.
+type Scenario struct {
+	Name  string
+	Pause time.Duration
+	Runs  []Run
+}
+
+// file mirrors the on-disk layout. Runs are decoded one by one on top of the
+// defaults so that every run only has to list what it changes.
+type file struct {
+	Name     string      `yaml:"name"`
+	Pause    string      `yaml:"pause"`
+	Defaults yaml.Node   `yaml:"defaults"`
+	Runs     []yaml.Node `yaml:"runs"`
+}
+
+// Load reads a YAML or JSON scenario file. Fields missing from a run are taken
+// from the file's "defaults" block and then from defaults.
+func Load(path string, defaults Run) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(f.Runs) == 0 {
+		return nil, fmt.Errorf("%s: no runs defined", path)
+	}
+
+	if !f.Defaults.IsZero() {
+		if err := f.Defaults.Decode(&defaults); err != nil {
+			return nil, fmt.Errorf("%s: defaults: %v", path, err)
+		}
+		payloadAlias(&f.Defaults, &defaults)
+	}
+
+	sc := &Scenario{Name: f.Name}
+	if f.Pause != "" {
+		if sc.Pause, err = time.ParseDuration(f.Pause); err != nil {
+			return nil, fmt.Errorf("%s: pause: %v", path, err)
+		}
+	}
+	for i, node := range f.Runs {
+		run := defaults
+		run.Name = ""
+		run.Operations = nil
+		if err := node.Decode(&run); err != nil {
+			return nil, fmt.Errorf("%s: run %d: %v", path, i+1, err)
+		}
+		if run.Name == "" {
+			run.Name = fmt.Sprintf("run-%d", i+1)
+		}
+		payloadAlias(&node, &run)
+		// An operation of the run's own replaces the default mix.
+		if run.Operations == nil && !hasKey(&node, "operation") {
+			run.Operations = defaults.Operations
+		}
+		sc.Runs = append(sc.Runs, run)
+	}
+	return sc, nil
+}
+
+// payloadAlias sets the run's codec from the deprecated payload field when the
+// node sets payload but not codec.
+func payloadAlias(node *yaml.Node, run *Run) {
+	if hasKey(node, "payload") && !hasKey(node, "codec") {
+		run.Codec = run.Payload
+	}
+}
+
+// hasKey reports whether the mapping node sets key.
+func hasKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Config converts the run into the RunConfig the client executes.
+func (r Run) Config() (report.RunConfig, error) {
+	cfg := report.RunConfig{
+		Name:          r.Name,
+		Mode:          r.Mode,
+		ClientID:      r.ClientID,
+		Workers:       r.Workers,
+		Streams:       r.Streams,
+		Conns:         r.Conns,
+		ConnPolicy:    r.ConnPolicy,
+		StreamPolicy:  r.StreamPolicy,
+		BatchSize:     r.BatchSize,
+		Acks:          r.Acks,
+		Reconnect:     report.Reconnect{Unanswered: r.Unanswered},
+		IntervalMs:    r.IntervalMs,
+		Profile:       r.Profile,
+		Transactions:  r.Transactions,
+		Operation:     r.Operation,
+		JWTGen:        r.JWTGen,
+		Codec:         r.Codec,
+		PayloadBytes:  r.PayloadBytes,
+		Padding:       r.Padding,
+		ResponseBytes: r.ResponseBytes,
+		X:             r.X,
+		Y:             r.Y,
+		SLO:           report.SLO{P99Ms: r.SLOP99Ms, ErrorRatePct: r.SLOErrorRate},
+	}
+	if r.Rate != "" {
+		rate, err := loadgen.ParseRate(r.Rate)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		cfg.Rate = rate
+	}
+	if r.Duration != "" {
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: duration: %v", r.Name, err)
+		}
+		cfg.Duration = report.Duration(d)
+	}
+	if r.Warmup != "" {
+		d, err := time.ParseDuration(r.Warmup)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: warmup: %v", r.Name, err)
+		}
+		cfg.Warmup = report.Duration(d)
+	}
+	if r.Cooldown != "" {
+		d, err := time.ParseDuration(r.Cooldown)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: cooldown: %v", r.Name, err)
+		}
+		cfg.Cooldown = report.Duration(d)
+	}
+	if len(r.Operations) > 0 {
+		cfg.Operation = MixSpec(r.Operations)
+	}
+	if _, err := loadgen.ParseMix(cfg.Operation); err != nil {
+		return cfg, fmt.Errorf("%s: %v", r.Name, err)
+	}
+	var profileLength time.Duration
+	if cfg.Profile != "" {
+		profile, err := loadgen.ParseProfile(cfg.Profile)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		profileLength = profile.Length()
+	}
+	if cfg.Transactions == 0 && cfg.Duration == 0 && profileLength == 0 {
+		return cfg, fmt.Errorf("%s: either transactions or duration must be set", r.Name)
+	}
+	return cfg, nil
+}
+
+// MixSpec formats a weighted operation mix as "ADD:3,ISPRIME:1", sorted by operation.
+func MixSpec(mix map[string]int) string {
+	ops := make([]string, 0, len(mix))
+	for op := range mix {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		parts = append(parts, op+":"+strconv.Itoa(mix[op]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Matrix is a parameter sweep. Every combination of the listed values is a
+// cell, and every cell is run Repeat times. Empty dimensions keep the value
+// of the base config.
+type Matrix struct {
+	Modes        []string
+	Workers      []int
+	Streams      []int
+	Conns        []int
+	BatchSizes   []int
+	JWTGen       []string
+	Codecs       []string
+	PayloadBytes []int
+	Operations   []string
+	X            []int
+	Repeat       int
+}
+
+// Expand returns the runs of the cartesian product of the matrix applied to
+// base, repetitions of a cell next to each other. Runs of the same cell share
+// a name and differ in Repetition.
+func (m Matrix) Expand(base report.RunConfig) []report.RunConfig {
+	cells := []report.RunConfig{base}
+	expand := func(n int, set func(cfg *report.RunConfig, i int)) {
+		if n == 0 {
+			return
+		}
+		next := make([]report.RunConfig, 0, len(cells)*n)
+		for _, cell := range cells {
+			for i := 0; i < n; i++ {
+				cfg := cell
+				set(&cfg, i)
+				next = append(next, cfg)
+			}
+		}
+		cells = next
+	}
+	expand(len(m.Modes), func(cfg *report.RunConfig, i int) { cfg.Mode = m.Modes[i] })
+	expand(len(m.Workers), func(cfg *report.RunConfig, i int) { cfg.Workers = m.Workers[i] })
+	expand(len(m.Streams), func(cfg *report.RunConfig, i int) { cfg.Streams = m.Streams[i] })
+	expand(len(m.Conns), func(cfg *report.RunConfig, i int) { cfg.Conns = m.Conns[i] })
+	expand(len(m.BatchSizes), func(cfg *report.RunConfig, i int) { cfg.BatchSize = m.BatchSizes[i] })
+	expand(len(m.JWTGen), func(cfg *report.RunConfig, i int) { cfg.JWTGen = m.JWTGen[i] })
+	expand(len(m.Codecs), func(cfg *report.RunConfig, i int) { cfg.Codec = m.Codecs[i] })
+	expand(len(m.PayloadBytes), func(cfg *report.RunConfig, i int) { cfg.PayloadBytes = m.PayloadBytes[i] })
+	expand(len(m.Operations), func(cfg *report.RunConfig, i int) { cfg.Operation = m.Operations[i] })
+	expand(len(m.X), func(cfg *report.RunConfig, i int) { cfg.X = m.X[i] })
+
+	repeat := m.Repeat
+	if repeat < 1 {
+		repeat = 1
+	}
+	runs := make([]report.RunConfig, 0, len(cells)*repeat)
+	for _, cell := range cells {
+		cell.Name = fmt.Sprintf("mode=%s workers=%d jwt=%s op=%s x=%d",
+			cell.Mode, cell.Workers, cell.JWTGen, cell.Operation, cell.X)
+		if len(m.Streams) > 0 {
+			cell.Name += fmt.Sprintf(" streams=%d", cell.Streams)
+		}
+		if len(m.Conns) > 0 {
+			cell.Name += fmt.Sprintf(" conns=%d", cell.Conns)
+		}
+		if len(m.BatchSizes) > 0 {
+			cell.Name += fmt.Sprintf(" batch=%d", cell.BatchSize)
+		}
+		if len(m.Codecs) > 0 {
+			cell.Name += " codec=" + cell.Codec
+		}
+		if len(m.PayloadBytes) > 0 {
+			cell.Name += fmt.Sprintf(" payload=%d", cell.PayloadBytes)
+		}
+		for rep := 1; rep <= repeat; rep++ {
+			cfg := cell
+			cfg.Repetition = rep
+			runs = append(runs, cfg)
+		}
+	}
+	return runs
+}
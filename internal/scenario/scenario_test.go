@@ -0,0 +1,74 @@
+package scenario
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func load(t *testing.T, content string) *Scenario {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := Load(path, Run{Mode: "unary", Operation: "ADD", Transactions: 10})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return sc
+}
+
+func TestLoadOperationOverridesDefaultMix(t *testing.T) {
+	sc := load(t, `
+defaults:
+  operations: {ADD: 3, ISPRIME: 1}
+runs:
+  - name: mix
+  - name: single
+    operation: SUBTRACT
+  - name: own-mix
+    operations: {MULTIPLY: 1}
+`)
+	want := map[string]string{
+		"mix":     "ADD:3,ISPRIME:1",
+		"single":  "SUBTRACT",
+		"own-mix": "MULTIPLY:1",
+	}
+	for _, run := range sc.Runs {
+		cfg, err := run.Config()
+		if err != nil {
+			t.Fatalf("%s: Config failed: %v", run.Name, err)
+		}
+		if cfg.Operation != want[run.Name] {
+			t.Errorf("%s: operation = %q, want %q", run.Name, cfg.Operation, want[run.Name])
+		}
+	}
+}
+
+func TestLoadPayloadIsCodecAlias(t *testing.T) {
+	sc := load(t, `
+defaults:
+  payload: proto
+runs:
+  - name: inherited
+  - name: alias
+    payload: gob
+  - name: codec-wins
+    payload: gob
+    codec: cbor
+  - name: codec
+    codec: msgpack
+`)
+	want := map[string]string{
+		"inherited":  "proto",
+		"alias":      "gob",
+		"codec-wins": "cbor",
+		"codec":      "msgpack",
+	}
+	for _, run := range sc.Runs {
+		if run.Codec != want[run.Name] {
+			t.Errorf("%s: codec = %q, want %q", run.Name, run.Codec, want[run.Name])
+		}
+	}
+}
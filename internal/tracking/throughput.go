@@ -0,0 +1,284 @@
+package tracking
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Second is one per-second bucket of a run's time series. InFlight is the
+// number of requests still waiting for a response when the bucket closed and
+// the latency percentiles cover the responses received during that second.
+// A bucket is cut short at the end of the run and at every phase change.
+type Second struct {
+	Second    int     `json:"second"`
+	Requests  int64   `json:"requests"`
+	Responses int64   `json:"responses"`
+	Errors    int64   `json:"errors"`
+	InFlight  int64   `json:"in_flight"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	Partial   bool    `json:"partial,omitempty"` // shorter than one second
+	Phase     Phase   `json:"phase,omitempty"`
+	Target    float64 `json:"target_rate,omitempty"` // target arrival rate of a load profile, per second
+}
+
+// Measured reports whether the bucket belongs to the measure phase. Buckets
+// of reports written before phases existed have no phase and count as measured.
+func (s Second) Measured() bool {
+	return s.Phase == "" || s.Phase == PhaseMeasure
+}
+
+// String returns a one line progress representation of the bucket.
+func (s Second) String() string {
+	line := fmt.Sprintf("[%4ds] req=%d res=%d err=%d inflight=%d p50=%.3fms p90=%.3fms p99=%.3fms max=%.3fms",
+		s.Second, s.Requests, s.Responses, s.Errors, s.InFlight, s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs)
+	if s.Target > 0 {
+		line += fmt.Sprintf(" target=%.2f/s", s.Target)
+	}
+	if !s.Measured() {
+		line += " (" + string(s.Phase) + ")"
+	}
+	return line
+}
+
+// TPSStats summarises the per-second request and response rates of a run.
+type TPSStats struct {
+	AverageRequest  float64 `json:"avg_request_tps"`
+	MaxRequest      int64   `json:"max_request_tps"`
+	AverageResponse float64 `json:"avg_response_tps"`
+	MaxResponse     int64   `json:"max_response_tps"`
+}
+
+// Meter counts requests, responses and errors and closes a Second bucket on
+// every tick of a one second ticker, taking the in-flight count and latency
+// window from its Tracker. Buckets are tagged with the meter's phase; only
+// measure phase buckets and errors count towards TPS and Errors.
+type Meter struct {
+	// OnSecond, if set before Start, is called with every closed bucket.
+	OnSecond func(Second)
+	// TargetRate, if set before Start, returns the average target rate
+	// between two times. Every bucket records the target over its length.
+	TargetRate func(from, to time.Time) float64
+	// Origin, if set before Start, is the time the first bucket opens, such
+	// as the start of a load profile. Buckets then close on whole seconds
+	// since Origin, also across phase changes, so none of them straddles two
+	// steps of the profile.
+	Origin time.Time
+
+	tracker   *Tracker
+	requests  int64
+	responses int64
+	errors    int64
+
+	mu         sync.Mutex
+	phase      Phase
+	series     []Second
+	lengths    []float64 // length in seconds of every bucket in series
+	errorKinds map[string]int64
+	lastTick   time.Time
+	stopped    bool
+
+	ticker *time.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewMeter creates and returns a new Meter reading latencies from tracker.
+func NewMeter(tracker *Tracker) *Meter {
+	return &Meter{
+		tracker:    tracker,
+		phase:      PhaseMeasure,
+		errorKinds: make(map[string]int64),
+	}
+}
+
+// Start begins closing a bucket every second.
+func (m *Meter) Start() {
+	m.lastTick = time.Now()
+	first := 1 * time.Second
+	if !m.Origin.IsZero() {
+		first -= m.lastTick.Sub(m.Origin) % time.Second
+		m.lastTick = m.Origin
+	}
+	m.ticker = time.NewTicker(first)
+	m.done = make(chan struct{})
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			select {
+			case now := <-m.ticker.C:
+				m.close(now)
+				if first != time.Second {
+					first = time.Second
+					m.ticker.Reset(first)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the ticker and closes the partially filled current second as a
+// final, partial bucket, so runs shorter than a second still have a rate.
+func (m *Meter) Stop() {
+	m.ticker.Stop()
+	close(m.done)
+	m.wg.Wait()
+	m.mu.Lock()
+	bucket, ok := m.closeLocked(time.Now())
+	m.stopped = true
+	m.mu.Unlock()
+	m.emit(bucket, ok)
+}
+
+// SetPhase closes the current bucket and tags the following ones with p.
+// Without an Origin the ticker restarts, so the buckets of the new phase are
+// full seconds; with one the first bucket of the new phase is partial.
+// It does nothing once the meter is stopped.
+func (m *Meter) SetPhase(p Phase) {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	bucket, ok := m.closeLocked(time.Now())
+	m.phase = p
+	m.mu.Unlock()
+	m.emit(bucket, ok)
+	if m.Origin.IsZero() {
+		m.ticker.Reset(1 * time.Second)
+	}
+}
+
+// close closes the current bucket at now.
+func (m *Meter) close(now time.Time) {
+	m.mu.Lock()
+	bucket, ok := m.closeLocked(now)
+	m.mu.Unlock()
+	m.emit(bucket, ok)
+}
+
+// closeLocked closes the current bucket at now and reports whether it was
+// kept. Buckets shorter than a second are marked partial; empty ones, such as
+// a tick racing a phase change, are skipped. m.mu must be held, so a bucket
+// is never counted by two racing closes.
+func (m *Meter) closeLocked(now time.Time) (Second, bool) {
+	opened := m.lastTick
+	length := now.Sub(opened).Seconds()
+	if length < 0.001 {
+		return Second{}, false
+	}
+	inFlight, latency := m.tracker.TakeWindow()
+	bucket := Second{
+		Second:    len(m.series) + 1,
+		Requests:  atomic.SwapInt64(&m.requests, 0),
+		Responses: atomic.SwapInt64(&m.responses, 0),
+		Errors:    atomic.SwapInt64(&m.errors, 0),
+		InFlight:  int64(inFlight),
+		P50Ms:     latency.MedianLatency,
+		P90Ms:     latency.P90Latency,
+		P99Ms:     latency.P99Latency,
+		MaxMs:     latency.MaxLatency,
+		Partial:   length < 0.99,
+		Phase:     m.phase,
+	}
+	if m.TargetRate != nil {
+		bucket.Target = m.TargetRate(opened, now)
+	}
+	m.series = append(m.series, bucket)
+	m.lengths = append(m.lengths, length)
+	m.lastTick = now
+	return bucket, true
+}
+
+// emit passes a kept bucket to OnSecond, outside of m.mu.
+func (m *Meter) emit(bucket Second, ok bool) {
+	if ok && m.OnSecond != nil {
+		m.OnSecond(bucket)
+	}
+}
+
+// AddRequest counts one successfully sent request.
+func (m *Meter) AddRequest() {
+	atomic.AddInt64(&m.requests, 1)
+}
+
+// AddResponse counts one received and correlated response.
+func (m *Meter) AddResponse() {
+	atomic.AddInt64(&m.responses, 1)
+}
+
+// AddError counts one error of the given kind, e.g. "send" or "verify".
+// Errors outside the measure phase only show in the per-second buckets.
+func (m *Meter) AddError(kind string) {
+	atomic.AddInt64(&m.errors, 1)
+	m.mu.Lock()
+	if m.phase == PhaseMeasure {
+		m.errorKinds[kind]++
+	}
+	m.mu.Unlock()
+}
+
+// Series returns a copy of the closed per-second buckets.
+func (m *Meter) Series() []Second {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Second(nil), m.series...)
+}
+
+// Errors returns a copy of the error counts by kind.
+func (m *Meter) Errors() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	errors := make(map[string]int64, len(m.errorKinds))
+	for k, v := range m.errorKinds {
+		errors[k] = v
+	}
+	return errors
+}
+
+// TPS computes average and maximum request and response rates over the
+// closed measure phase buckets. Partial buckets count for their actual length
+// and only contribute to the maximum if there is no full bucket.
+func (m *Meter) TPS() TPSStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var stats TPSStats
+	var totalReq, totalRes int64
+	var seconds float64
+	full := false
+	for i, s := range m.series {
+		if s.Phase != PhaseMeasure {
+			continue
+		}
+		totalReq += s.Requests
+		totalRes += s.Responses
+		seconds += m.lengths[i]
+		if s.Partial {
+			continue
+		}
+		full = true
+		if s.Requests > stats.MaxRequest {
+			stats.MaxRequest = s.Requests
+		}
+		if s.Responses > stats.MaxResponse {
+			stats.MaxResponse = s.Responses
+		}
+	}
+	if seconds == 0 {
+		return stats
+	}
+	stats.AverageRequest = float64(totalReq) / seconds
+	stats.AverageResponse = float64(totalRes) / seconds
+	if !full {
+		stats.MaxRequest = int64(stats.AverageRequest)
+		stats.MaxResponse = int64(stats.AverageResponse)
+	}
+	return stats
+}
@@ -3,188 +3,628 @@ package tracking
 import (
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"gonum.org/v1/gonum/stat"
+	"github.com/HdrHistogram/hdrhistogram-go"
 	"grpc-benchmark-study/internal/calculation"
 )
 
+// Histogram bounds. Latencies are recorded in microseconds from 1µs up to one
+// hour with three significant digits, which keeps each histogram at a fixed
+// size no matter how many transactions a run sends.
+const (
+	histogramMinMicros = 1
+	histogramMaxMicros = int64(time.Hour / time.Microsecond)
+	histogramSigFigs   = 3
+)
+
+// Phase is the part of a run a sample was taken in. Only samples of the
+// measure phase make it into the reported statistics; warm-up and cool-down
+// samples are tracked and counted separately.
+type Phase string
+
+const (
+	PhaseWarmup   Phase = "warmup"
+	PhaseMeasure  Phase = "measure"
+	PhaseCooldown Phase = "cooldown"
+)
+
+// PhaseCount holds the number of entries sent, answered and given up as lost
+// in one phase.
+type PhaseCount struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+	Lost     int64 `json:"lost,omitempty"`
+}
+
+// Reconnect is one break of a stream and its recovery. Resent and Lost count
+// the transactions the break left unanswered, as settled by the client's
+// policy.
+type Reconnect struct {
+	Stream     string    `json:"stream"` // e.g. "stream=1" or "subscription"
+	At         time.Time `json:"at"`     // when the break was noticed
+	DowntimeMs float64   `json:"downtime_ms"`
+	Attempts   int       `json:"attempts"`
+	Resent     int       `json:"resent,omitempty"`
+	Lost       int       `json:"lost,omitempty"`
+	Error      string    `json:"error"`
+}
+
 // TrackingEntry holds the sent Calculation, the response Calculation,
-// a flag indicating if the response was received, and the latency in ms.
+// a flag indicating if the response was received, and the latency.
+// CorrectedLatency is measured from the intended send time instead of the
+// actual one, so it also includes any time the send spent queued behind the
+// load schedule (coordinated omission).
 type TrackingEntry struct {
-	Sent      calculation.Calculation
-	Response  calculation.Calculation
-	Received  bool
-	LatencyMs int64
-	SentAt    time.Time // internal field used to compute latency
+	Sent             calculation.Calculation
+	Response         calculation.Calculation
+	Received         bool
+	Latency          time.Duration
+	CorrectedLatency time.Duration
+	SentAt           time.Time // internal field used to compute latency
+	IntendedAt       time.Time // when the load schedule wanted the send to happen (zero in closed-loop mode)
+	Phase            Phase     // phase of the run the entry was sent in
+	Labels           []string  // breakdown labels such as "stream=3", see AddLabeled
 }
 
-// Tracker holds a map of Calculation.ID to TrackingEntry and a mutex for safe concurrent access.
+// Tracker keeps in-flight entries keyed by Calculation.ID and records the
+// latency of every response into fixed-size histograms. Entries are dropped
+// once answered, so memory is bounded by the number of in-flight requests
+// plus the retained slow entries rather than by the length of the run; the
+// answered IDs are only remembered as one bit each, to detect duplicates.
 type Tracker struct {
 	mu        sync.Mutex
-	data      map[int32]*TrackingEntry
+	pending   map[int32]*TrackingEntry
+	answered  []uint64 // bit set of the answered IDs
+	dupes     int64    // responses for IDs already answered
+	latencies *hdrhistogram.Histogram
+	corrected *hdrhistogram.Histogram
+	window    *hdrhistogram.Histogram // latencies since the last TakeWindow, of every phase
+	phase     Phase
+	counts    map[Phase]*PhaseCount
 	startTime time.Time
 	endTime   time.Time
-	duration  time.Duration
+
+	// Send lag of scheduled (open-loop) entries.
+	lagCount int64
+	lagSum   time.Duration
+	lagMax   time.Duration
+
+	// Per-label counts and latencies, see AddLabeled.
+	labels map[string]*labelStats
+
+	// Streams that broke and were opened again, see RecordReconnect.
+	reconnects []Reconnect
+
+	// Slow entries retained for the end-of-run listing.
+	slowThreshold time.Duration
+	slowLimit     int
+	slow          []TrackingEntry
 }
 
-// LatencyStats holds a summary of latency metrics.
+// LatencyStats holds a summary of latency metrics, all in ms at microsecond resolution.
 type LatencyStats struct {
-	AverageLatency float64 // average latency in ms
-	MedianLatency  float64 // 50th percentile in ms
-	P90Latency     float64 // 90th percentile in ms
-	P95Latency     float64 // 95th percentile in ms
-	MaxLatency     int64   // maximum latency in ms
-	MinLatency     int64   // minimum latency in ms
-	StdDevLatency  float64 // standard deviation in ms
+	Count          int64   `json:"count"`     // number of samples
+	AverageLatency float64 `json:"avg_ms"`    // average latency in ms
+	MedianLatency  float64 `json:"p50_ms"`    // 50th percentile in ms
+	P90Latency     float64 `json:"p90_ms"`    // 90th percentile in ms
+	P95Latency     float64 `json:"p95_ms"`    // 95th percentile in ms
+	P99Latency     float64 `json:"p99_ms"`    // 99th percentile in ms
+	P999Latency    float64 `json:"p999_ms"`   // 99.9th percentile in ms
+	P9999Latency   float64 `json:"p9999_ms"`  // 99.99th percentile in ms
+	MaxLatency     float64 `json:"max_ms"`    // maximum latency in ms
+	MinLatency     float64 `json:"min_ms"`    // minimum latency in ms
+	StdDevLatency  float64 `json:"stddev_ms"` // standard deviation in ms
 }
 
 // String returns a nicely formatted string representation of the latency stats.
 func (ls LatencyStats) String() string {
 	return fmt.Sprintf(
 		"Latency Summary:\n"+
-			"  Average Latency: %.2f ms\n"+
-			"  Median Latency: %.2f ms\n"+
-			"  90th Percentile: %.2f ms\n"+
-			"  95th Percentile: %.2f ms\n"+
-			"  Minimum Latency: %d ms\n"+
-			"  Maximum Latency: %d ms\n"+
-			"  Standard Deviation: %.2f ms",
+			"  Average Latency: %.3f ms\n"+
+			"  Median Latency: %.3f ms\n"+
+			"  90th Percentile: %.3f ms\n"+
+			"  95th Percentile: %.3f ms\n"+
+			"  99th Percentile: %.3f ms\n"+
+			"  99.9th Percentile: %.3f ms\n"+
+			"  99.99th Percentile: %.3f ms\n"+
+			"  Minimum Latency: %.3f ms\n"+
+			"  Maximum Latency: %.3f ms\n"+
+			"  Standard Deviation: %.3f ms",
 		ls.AverageLatency, ls.MedianLatency, ls.P90Latency, ls.P95Latency,
+		ls.P99Latency, ls.P999Latency, ls.P9999Latency,
 		ls.MinLatency, ls.MaxLatency, ls.StdDevLatency)
 }
 
+// LatencyReport holds the uncorrected latency stats (measured from the actual
+// send) next to the coordinated-omission-corrected ones (measured from the
+// intended send time).
+type LatencyReport struct {
+	Uncorrected LatencyStats `json:"uncorrected"`
+	Corrected   LatencyStats `json:"corrected"`
+}
+
+// String returns the uncorrected and corrected latency stats side by side.
+func (lr LatencyReport) String() string {
+	u, c := lr.Uncorrected, lr.Corrected
+	return fmt.Sprintf(
+		"Latency Summary:         uncorrected     corrected\n"+
+			"  Average Latency:     %10.3f ms %10.3f ms\n"+
+			"  Median Latency:      %10.3f ms %10.3f ms\n"+
+			"  90th Percentile:     %10.3f ms %10.3f ms\n"+
+			"  95th Percentile:     %10.3f ms %10.3f ms\n"+
+			"  99th Percentile:     %10.3f ms %10.3f ms\n"+
+			"  99.9th Percentile:   %10.3f ms %10.3f ms\n"+
+			"  99.99th Percentile:  %10.3f ms %10.3f ms\n"+
+			"  Minimum Latency:     %10.3f ms %10.3f ms\n"+
+			"  Maximum Latency:     %10.3f ms %10.3f ms\n"+
+			"  Standard Deviation:  %10.3f ms %10.3f ms",
+		u.AverageLatency, c.AverageLatency, u.MedianLatency, c.MedianLatency,
+		u.P90Latency, c.P90Latency, u.P95Latency, c.P95Latency,
+		u.P99Latency, c.P99Latency, u.P999Latency, c.P999Latency,
+		u.P9999Latency, c.P9999Latency,
+		u.MinLatency, c.MinLatency, u.MaxLatency, c.MaxLatency,
+		u.StdDevLatency, c.StdDevLatency)
+}
+
 // NewTracker creates and returns a new Tracker.
 func NewTracker() *Tracker {
 	return &Tracker{
-		data: make(map[int32]*TrackingEntry),
+		pending:   make(map[int32]*TrackingEntry),
+		latencies: newHistogram(),
+		corrected: newHistogram(),
+		window:    newHistogram(),
+		phase:     PhaseMeasure,
+		counts:    make(map[Phase]*PhaseCount),
+		labels:    make(map[string]*labelStats),
 	}
 }
 
+type labelStats struct {
+	sent, received int64
+	latencies      *hdrhistogram.Histogram
+}
+
+// LabelStats is the share of the measure phase that carried one label.
+type LabelStats struct {
+	Label    string       `json:"label"`
+	Sent     int64        `json:"sent"`
+	Received int64        `json:"received"`
+	Latency  LatencyStats `json:"latency"`
+}
+
+func newHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histogramMinMicros, histogramMaxMicros, histogramSigFigs)
+}
+
+// KeepSlowerThan makes the tracker retain up to limit answered entries whose
+// latency exceeds threshold, for listing with SlowEntries.
+func (t *Tracker) KeepSlowerThan(threshold time.Duration, limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slowThreshold = threshold
+	t.slowLimit = limit
+}
+
 // AddSent registers a sent Calculation. It records the sent Calculation and the current time.
 func (t *Tracker) AddSent(calc calculation.Calculation) {
+	t.AddScheduled(calc, time.Time{})
+}
+
+// AddScheduled registers a sent Calculation together with the time the load
+// schedule intended it to be sent at.
+func (t *Tracker) AddScheduled(calc calculation.Calculation, intended time.Time) {
+	t.AddLabeled(calc, intended)
+}
+
+// AddLabeled is AddScheduled for an entry that is also counted under each of
+// labels, e.g. the stream or connection it was sent on. LabelSummary breaks
+// the measure phase down by label. The padding of calc is not kept.
+func (t *Tracker) AddLabeled(calc calculation.Calculation, intended time.Time, labels ...string) {
+	now := time.Now()
+	calc.Padding = nil
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.data[calc.ID] = &TrackingEntry{
-		Sent:     calc,
-		SentAt:   time.Now(),
-		Received: false,
+	t.pending[calc.ID] = &TrackingEntry{
+		Sent:       calc,
+		SentAt:     now,
+		IntendedAt: intended,
+		Received:   false,
+		Phase:      t.phase,
+		Labels:     labels,
+	}
+	t.count(t.phase).Sent++
+	if t.phase == PhaseMeasure {
+		for _, l := range labels {
+			t.label(l).sent++
+		}
+	}
+	if !intended.IsZero() && t.phase == PhaseMeasure {
+		lag := now.Sub(intended)
+		if lag < 0 {
+			lag = 0
+		}
+		t.lagCount++
+		t.lagSum += lag
+		if lag > t.lagMax {
+			t.lagMax = lag
+		}
 	}
 }
 
 // RecordResponse records the response Calculation for the given ID and computes the latency.
-func (t *Tracker) RecordResponse(response calculation.Calculation) {
+// It returns the completed entry, or false if the ID is not in flight. Labels
+// only known on receipt, such as the backend that answered, are added to the
+// entry's own; their sends are counted with CountSent. Like that of the sent
+// Calculation, the padding of the response is not kept.
+func (t *Tracker) RecordResponse(response calculation.Calculation, labels ...string) (TrackingEntry, bool) {
+	now := time.Now()
+	response.Padding = nil
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	if entry, ok := t.data[response.ID]; ok {
-		entry.Response = response
-		entry.Received = true
-		entry.LatencyMs = time.Since(entry.SentAt).Milliseconds()
+	entry, ok := t.pending[response.ID]
+	if !ok {
+		if t.isAnswered(response.ID) {
+			t.dupes++
+		}
+		return TrackingEntry{}, false
+	}
+	delete(t.pending, response.ID)
+	if id := response.ID; id >= 0 {
+		for int(id/64) >= len(t.answered) {
+			t.answered = append(t.answered, 0)
+		}
+		t.answered[id/64] |= 1 << (id % 64)
+	}
+	if len(labels) > 0 {
+		entry.Labels = append(append([]string(nil), entry.Labels...), labels...)
+	}
+	entry.Response = response
+	entry.Received = true
+	entry.Latency = now.Sub(entry.SentAt)
+	entry.CorrectedLatency = entry.Latency
+	if !entry.IntendedAt.IsZero() && entry.IntendedAt.Before(entry.SentAt) {
+		entry.CorrectedLatency = now.Sub(entry.IntendedAt)
 	}
+	t.count(entry.Phase).Received++
+	recordMicros(t.window, entry.Latency)
+	if entry.Phase != PhaseMeasure {
+		return *entry, true
+	}
+	recordMicros(t.latencies, entry.Latency)
+	recordMicros(t.corrected, entry.CorrectedLatency)
+	for _, l := range entry.Labels {
+		ls := t.label(l)
+		ls.received++
+		recordMicros(ls.latencies, entry.Latency)
+	}
+	if t.slowLimit > 0 && entry.Latency > t.slowThreshold && len(t.slow) < t.slowLimit {
+		t.slow = append(t.slow, *entry)
+	}
+	return *entry, true
 }
 
-// GetEntry retrieves the tracking entry for a given Calculation.ID.
+// Answered reports whether a response for id was already recorded, so that a
+// response RecordResponse did not accept can be told apart as a duplicate.
+func (t *Tracker) Answered(id int32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.isAnswered(id)
+}
+
+func (t *Tracker) isAnswered(id int32) bool {
+	return id >= 0 && int(id/64) < len(t.answered) && t.answered[id/64]&(1<<(id%64)) != 0
+}
+
+// Duplicates returns the number of responses received for IDs that were
+// already answered, as sent again by at-least-once delivery.
+func (t *Tracker) Duplicates() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dupes
+}
+
+// Unanswered returns the entries sent before the given time that are still
+// waiting for a response, ordered by ID.
+func (t *Tracker) Unanswered(before time.Time) []TrackingEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var entries []TrackingEntry
+	for _, entry := range t.pending {
+		if entry.SentAt.Before(before) {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sent.ID < entries[j].Sent.ID })
+	return entries
+}
+
+// MarkLost gives up on the in-flight entries with the given IDs, so that they
+// no longer count as in flight, and returns how many were still in flight.
+func (t *Tracker) MarkLost(ids ...int32) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		entry, ok := t.pending[id]
+		if !ok {
+			continue
+		}
+		delete(t.pending, id)
+		t.count(entry.Phase).Lost++
+		n++
+	}
+	return n
+}
+
+// ExpireSentBefore gives up on the entries sent before the given time that
+// are still waiting for a response, like MarkLost, and returns how many.
+func (t *Tracker) ExpireSentBefore(before time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for id, entry := range t.pending {
+		if !entry.SentAt.Before(before) {
+			continue
+		}
+		delete(t.pending, id)
+		t.count(entry.Phase).Lost++
+		n++
+	}
+	return n
+}
+
+// Lost returns the number of entries of the measure phase given up as lost.
+func (t *Tracker) Lost() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count(PhaseMeasure).Lost
+}
+
+// RecordReconnect records a stream that broke and was opened again.
+func (t *Tracker) RecordReconnect(r Reconnect) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reconnects = append(t.reconnects, r)
+}
+
+// Reconnects returns the recorded reconnections in the order they completed.
+func (t *Tracker) Reconnects() []Reconnect {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Reconnect(nil), t.reconnects...)
+}
+
+func (t *Tracker) count(p Phase) *PhaseCount {
+	c, ok := t.counts[p]
+	if !ok {
+		c = &PhaseCount{}
+		t.counts[p] = c
+	}
+	return c
+}
+
+// CountSent counts one send of the measure phase under label, for labels that
+// are only known once the send completed.
+func (t *Tracker) CountSent(label string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.phase == PhaseMeasure {
+		t.label(label).sent++
+	}
+}
+
+func (t *Tracker) label(l string) *labelStats {
+	ls, ok := t.labels[l]
+	if !ok {
+		ls = &labelStats{latencies: newHistogram()}
+		t.labels[l] = ls
+	}
+	return ls
+}
+
+// recordMicros records d in h, clamping it to the histogram's trackable range.
+func recordMicros(h *hdrhistogram.Histogram, d time.Duration) {
+	us := d.Microseconds()
+	if us < histogramMinMicros {
+		us = histogramMinMicros
+	}
+	if us > histogramMaxMicros {
+		us = histogramMaxMicros
+	}
+	_ = h.RecordValue(us)
+}
+
+// GetEntry retrieves the in-flight tracking entry for a given Calculation.ID.
 func (t *Tracker) GetEntry(id int32) (*TrackingEntry, bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	entry, ok := t.data[id]
+	entry, ok := t.pending[id]
 	return entry, ok
 }
 
-// Data returns a copy of the internal tracking map.
-func (t *Tracker) Data() map[int32]*TrackingEntry {
+// Pending returns a copy of the entries that are still waiting for a response.
+func (t *Tracker) Pending() map[int32]*TrackingEntry {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	copyMap := make(map[int32]*TrackingEntry, len(t.data))
-	for k, v := range t.data {
+	copyMap := make(map[int32]*TrackingEntry, len(t.pending))
+	for k, v := range t.pending {
 		copyMap[k] = v
 	}
 	return copyMap
 }
 
+// SlowEntries returns the retained entries slower than the KeepSlowerThan threshold.
+func (t *Tracker) SlowEntries() []TrackingEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TrackingEntry(nil), t.slow...)
+}
+
 func (t *Tracker) Start() {
 	t.mu.Lock()
 	t.startTime = time.Now()
 	t.mu.Unlock()
 }
 
+// SetPhase tags entries sent from now on with p. Entering the measure phase
+// restarts the clock and entering the cool-down phase stops it, so Duration
+// covers the measure phase only.
+func (t *Tracker) SetPhase(p Phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case p == PhaseMeasure && t.phase != PhaseMeasure:
+		t.startTime = time.Now()
+	case p == PhaseCooldown && t.phase == PhaseMeasure:
+		t.endTime = time.Now()
+	}
+	t.phase = p
+}
+
+// Stop stops the clock, unless the cool-down phase already did.
 func (t *Tracker) Stop() {
 	t.mu.Lock()
-	t.endTime = time.Now()
+	if t.phase != PhaseCooldown {
+		t.endTime = time.Now()
+	}
 	t.mu.Unlock()
 }
 
+// StartTime returns the start of the measure phase.
+func (t *Tracker) StartTime() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.startTime
+}
+
 func (t *Tracker) Duration() time.Duration {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	return t.endTime.Sub(t.startTime)
 }
 
-// LatencySummary computes and returns a summary of latency statistics from all received entries.
-func (t *Tracker) LatencySummary() LatencyStats {
+// LatencySummary computes and returns a summary of latency statistics from all received entries,
+// both as measured from the actual send and corrected to the intended send time.
+func (t *Tracker) LatencySummary() LatencyReport {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return LatencyReport{
+		Uncorrected: histogramStats(t.latencies),
+		Corrected:   histogramStats(t.corrected),
+	}
+}
 
-	var latencies []float64
-	var sum float64
-	var count int64
-	var max int64 = 0
-	var min int64 = 0
-
-	// Collect latencies from all entries that received a response.
-	for _, entry := range t.data {
-		if !entry.Received {
-			continue
-		}
-		lat := float64(entry.LatencyMs)
-		latencies = append(latencies, lat)
-		sum += lat
-		count++
-		if entry.LatencyMs > max {
-			max = entry.LatencyMs
-		}
-		if min == 0 || entry.LatencyMs < min {
-			min = entry.LatencyMs
-		}
+// LabelSummary returns the counts and uncorrected latencies of the measure
+// phase per label, sorted by label.
+func (t *Tracker) LabelSummary() []LabelStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	summary := make([]LabelStats, 0, len(t.labels))
+	for l, ls := range t.labels {
+		summary = append(summary, LabelStats{
+			Label:    l,
+			Sent:     ls.sent,
+			Received: ls.received,
+			Latency:  histogramStats(ls.latencies),
+		})
 	}
+	sort.Slice(summary, func(i, j int) bool { return labelLess(summary[i].Label, summary[j].Label) })
+	return summary
+}
 
-	var avg float64
-	if count > 0 {
-		avg = sum / float64(count)
+// labelLess orders "key=value" labels by key, then numerically by value if
+// both values are numbers, so "stream=2" sorts before "stream=10".
+func labelLess(a, b string) bool {
+	ak, av, _ := strings.Cut(a, "=")
+	bk, bv, _ := strings.Cut(b, "=")
+	if ak != bk {
+		return ak < bk
+	}
+	an, aerr := strconv.Atoi(av)
+	bn, berr := strconv.Atoi(bv)
+	if aerr == nil && berr == nil {
+		return an < bn
 	}
+	return av < bv
+}
 
-	// Sort latencies to compute percentiles.
-	sort.Float64s(latencies)
+// TakeWindow returns the number of in-flight entries and the latency stats of
+// the responses recorded since the previous call, then starts a new window.
+func (t *Tracker) TakeWindow() (inFlight int, latency LatencyStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	latency = histogramStats(t.window)
+	t.window.Reset()
+	return len(t.pending), latency
+}
 
-	var median, p90, p95 float64
-	if count > 0 {
-		median = stat.Quantile(0.5, stat.Empirical, latencies, nil)
-		p90 = stat.Quantile(0.90, stat.Empirical, latencies, nil)
-		p95 = stat.Quantile(0.95, stat.Empirical, latencies, nil)
+// histogramStats summarises a microsecond histogram in ms.
+func histogramStats(h *hdrhistogram.Histogram) LatencyStats {
+	if h.TotalCount() == 0 {
+		return LatencyStats{}
 	}
+	ms := func(us int64) float64 { return float64(us) / 1000 }
+	return LatencyStats{
+		Count:          h.TotalCount(),
+		AverageLatency: h.Mean() / 1000,
+		MedianLatency:  ms(h.ValueAtQuantile(50)),
+		P90Latency:     ms(h.ValueAtQuantile(90)),
+		P95Latency:     ms(h.ValueAtQuantile(95)),
+		P99Latency:     ms(h.ValueAtQuantile(99)),
+		P999Latency:    ms(h.ValueAtQuantile(99.9)),
+		P9999Latency:   ms(h.ValueAtQuantile(99.99)),
+		MaxLatency:     ms(h.Max()),
+		MinLatency:     ms(h.Min()),
+		StdDevLatency:  h.StdDev() / 1000,
+	}
+}
 
-	// Compute standard deviation.
-	stdDev := stat.StdDev(latencies, nil)
+// Counts returns the number of entries sent in the measure phase and of
+// their received responses.
+func (t *Tracker) Counts() (sent, received int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.count(PhaseMeasure)
+	return c.Sent, c.Received
+}
 
-	return LatencyStats{
-		AverageLatency: avg,
-		MedianLatency:  median,
-		P90Latency:     p90,
-		P95Latency:     p95,
-		MaxLatency:     max,
-		MinLatency:     min,
-		StdDevLatency:  stdDev,
+// PhaseCounts returns the sent and received counts of every phase that saw traffic.
+func (t *Tracker) PhaseCounts() map[Phase]PhaseCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[Phase]PhaseCount, len(t.counts))
+	for p, c := range t.counts {
+		counts[p] = *c
 	}
+	return counts
 }
 
 func (t *Tracker) SentReceivedSummary() string {
-	total := len(t.data)
-	receivedCount := 0
-	for _, entry := range t.data {
-		if entry.Received {
-			receivedCount++
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.count(PhaseMeasure)
+	summary := fmt.Sprintf("Total Entries: %d, Received: %d", c.Sent, c.Received)
+	for _, p := range []Phase{PhaseWarmup, PhaseCooldown} {
+		if c, ok := t.counts[p]; ok {
+			summary += fmt.Sprintf(" (excluded %s: %d sent, %d received)", p, c.Sent, c.Received)
 		}
 	}
-	return fmt.Sprintf("Total Entries: %d, Received: %d", total, receivedCount)
+	return summary
+}
+
+// SendLagSummary reports how far actual sends trailed their intended send time.
+// Only scheduled (open-loop) entries are considered.
+func (t *Tracker) SendLagSummary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lagCount == 0 {
+		return "Send Lag: n/a (closed-loop)"
+	}
+	avg := t.lagSum / time.Duration(t.lagCount)
+	return fmt.Sprintf("Send Lag: Average %.2f ms, Maximum %.2f ms",
+		float64(avg)/float64(time.Millisecond), float64(t.lagMax)/float64(time.Millisecond))
 }
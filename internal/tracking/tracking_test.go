@@ -0,0 +1,141 @@
+package tracking
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"grpc-benchmark-study/internal/calculation"
+)
+
+// send adds an entry for id to the tracker as if it was sent ago.
+func send(t *Tracker, id int32, ago time.Duration) {
+	t.AddSent(calculation.Calculation{ID: id})
+	t.pending[id].SentAt = time.Now().Add(-ago)
+}
+
+func answer(t *Tracker, id int32) bool {
+	_, ok := t.RecordResponse(calculation.Calculation{ID: id})
+	return ok
+}
+
+func TestKeepSlowerThanLimit(t *testing.T) {
+	tr := NewTracker()
+	tr.KeepSlowerThan(100*time.Millisecond, 2)
+	for id, ago := range []time.Duration{time.Second, 0, time.Second, time.Second, time.Second} {
+		send(tr, int32(id), ago)
+	}
+	for id := range int32(5) {
+		answer(tr, id)
+	}
+	var ids []int32
+	for _, e := range tr.SlowEntries() {
+		ids = append(ids, e.Sent.ID)
+	}
+	if want := []int32{0, 2}; !slices.Equal(ids, want) {
+		t.Fatalf("slow entries = %v, want %v", ids, want)
+	}
+}
+
+func TestKeepSlowerThanMeasureOnly(t *testing.T) {
+	tr := NewTracker()
+	tr.KeepSlowerThan(0, 10)
+	tr.SetPhase(PhaseWarmup)
+	send(tr, 1, time.Second)
+	tr.SetPhase(PhaseMeasure)
+	send(tr, 2, time.Second)
+	answer(tr, 1)
+	answer(tr, 2)
+	if slow := tr.SlowEntries(); len(slow) != 1 || slow[0].Sent.ID != 2 {
+		t.Fatalf("slow entries = %v, want only ID 2", slow)
+	}
+}
+
+func TestAnswered(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       int32
+		answered bool
+	}{
+		{"first bit", 0, true},
+		{"last bit of a word", 63, true},
+		{"first bit of the next word", 64, true},
+		{"far out", 1000, true},
+		{"unanswered next to answered", 1, false},
+		{"unanswered beyond the set", 5000, false},
+		{"negative", -1, false},
+	}
+	tr := NewTracker()
+	for _, tt := range tests {
+		send(tr, tt.id, 0)
+		if tt.answered && !answer(tr, tt.id) {
+			t.Fatalf("RecordResponse(%d) not accepted", tt.id)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tr.Answered(tt.id); got != tt.answered {
+				t.Fatalf("Answered(%d) = %v, want %v", tt.id, got, tt.answered)
+			}
+		})
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	tr := NewTracker()
+	send(tr, 64, 0)
+	answer(tr, 64)
+	if answer(tr, 64) {
+		t.Fatal("second response for 64 accepted")
+	}
+	if answer(tr, 65) {
+		t.Fatal("response for unknown 65 accepted")
+	}
+	if got := tr.Duplicates(); got != 1 {
+		t.Fatalf("duplicates = %d, want 1 (an unknown ID is no duplicate)", got)
+	}
+}
+
+func TestMarkLost(t *testing.T) {
+	tr := NewTracker()
+	for id := range int32(3) {
+		send(tr, id, 0)
+	}
+	if n := tr.MarkLost(0, 1, 99); n != 2 {
+		t.Fatalf("MarkLost = %d, want 2", n)
+	}
+	if n := tr.MarkLost(0); n != 0 {
+		t.Fatalf("MarkLost again = %d, want 0", n)
+	}
+	if answer(tr, 0) {
+		t.Fatal("response for a lost entry accepted")
+	}
+	if tr.Duplicates() != 0 || tr.Answered(0) {
+		t.Fatal("a lost entry counts as answered")
+	}
+	if _, ok := tr.Pending()[2]; !ok || len(tr.Pending()) != 1 {
+		t.Fatalf("pending = %v, want only ID 2", tr.Pending())
+	}
+	if lost := tr.Lost(); lost != 2 {
+		t.Fatalf("lost = %d, want 2", lost)
+	}
+}
+
+func TestExpireSentBefore(t *testing.T) {
+	tr := NewTracker()
+	tr.SetPhase(PhaseWarmup)
+	send(tr, 1, time.Minute)
+	tr.SetPhase(PhaseMeasure)
+	send(tr, 2, time.Minute)
+	send(tr, 3, 0)
+	if n := tr.ExpireSentBefore(time.Now().Add(-30 * time.Second)); n != 2 {
+		t.Fatalf("ExpireSentBefore = %d, want 2", n)
+	}
+	counts := tr.PhaseCounts()
+	if counts[PhaseWarmup].Lost != 1 || counts[PhaseMeasure].Lost != 1 {
+		t.Fatalf("phase counts = %v, want one lost in warm-up and in measure", counts)
+	}
+	if _, ok := tr.GetEntry(3); !ok {
+		t.Fatal("recent entry expired")
+	}
+}
@@ -20,9 +20,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	CalculatorService_PerformCalculationBi_FullMethodName   = "/calculator.CalculatorService/performCalculationBi"
-	CalculatorService_PerformCalculationTo_FullMethodName   = "/calculator.CalculatorService/performCalculationTo"
-	CalculatorService_PerformCalculationFrom_FullMethodName = "/calculator.CalculatorService/performCalculationFrom"
+	CalculatorService_PerformCalculationBi_FullMethodName    = "/calculator.CalculatorService/performCalculationBi"
+	CalculatorService_PerformCalculationTo_FullMethodName    = "/calculator.CalculatorService/performCalculationTo"
+	CalculatorService_PerformCalculationFrom_FullMethodName  = "/calculator.CalculatorService/performCalculationFrom"
+	CalculatorService_PerformCalculation_FullMethodName      = "/calculator.CalculatorService/performCalculation"
+	CalculatorService_PerformCalculationBatch_FullMethodName = "/calculator.CalculatorService/performCalculationBatch"
+	CalculatorService_Ack_FullMethodName                     = "/calculator.CalculatorService/ack"
 )
 
 // CalculatorServiceClient is the client API for CalculatorService service.
@@ -32,6 +35,12 @@ type CalculatorServiceClient interface {
 	PerformCalculationBi(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CalcMessage, CalcMessage], error)
 	PerformCalculationTo(ctx context.Context, in *CalcMessage, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	PerformCalculationFrom(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CalcMessage], error)
+	// Plain request/response: the result is the response of the call.
+	PerformCalculation(ctx context.Context, in *CalcMessage, opts ...grpc.CallOption) (*CalcMessage, error)
+	// Client streaming: the results of a whole stream come back at once.
+	PerformCalculationBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[CalcMessage, CalcBatchResult], error)
+	// Acknowledges results received on performCalculationFrom.
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
 
 type calculatorServiceClient struct {
@@ -84,6 +93,39 @@ func (c *calculatorServiceClient) PerformCalculationFrom(ctx context.Context, in
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type CalculatorService_PerformCalculationFromClient = grpc.ServerStreamingClient[CalcMessage]
 
+func (c *calculatorServiceClient) PerformCalculation(ctx context.Context, in *CalcMessage, opts ...grpc.CallOption) (*CalcMessage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CalcMessage)
+	err := c.cc.Invoke(ctx, CalculatorService_PerformCalculation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorServiceClient) PerformCalculationBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[CalcMessage, CalcBatchResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CalculatorService_ServiceDesc.Streams[2], CalculatorService_PerformCalculationBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CalcMessage, CalcBatchResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CalculatorService_PerformCalculationBatchClient = grpc.ClientStreamingClient[CalcMessage, CalcBatchResult]
+
+func (c *calculatorServiceClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CalculatorService_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CalculatorServiceServer is the server API for CalculatorService service.
 // All implementations must embed UnimplementedCalculatorServiceServer
 // for forward compatibility.
@@ -91,6 +133,12 @@ type CalculatorServiceServer interface {
 	PerformCalculationBi(grpc.BidiStreamingServer[CalcMessage, CalcMessage]) error
 	PerformCalculationTo(context.Context, *CalcMessage) (*emptypb.Empty, error)
 	PerformCalculationFrom(*emptypb.Empty, grpc.ServerStreamingServer[CalcMessage]) error
+	// Plain request/response: the result is the response of the call.
+	PerformCalculation(context.Context, *CalcMessage) (*CalcMessage, error)
+	// Client streaming: the results of a whole stream come back at once.
+	PerformCalculationBatch(grpc.ClientStreamingServer[CalcMessage, CalcBatchResult]) error
+	// Acknowledges results received on performCalculationFrom.
+	Ack(context.Context, *AckRequest) (*emptypb.Empty, error)
 	mustEmbedUnimplementedCalculatorServiceServer()
 }
 
@@ -110,6 +158,15 @@ func (UnimplementedCalculatorServiceServer) PerformCalculationTo(context.Context
 func (UnimplementedCalculatorServiceServer) PerformCalculationFrom(*emptypb.Empty, grpc.ServerStreamingServer[CalcMessage]) error {
 	return status.Errorf(codes.Unimplemented, "method PerformCalculationFrom not implemented")
 }
+func (UnimplementedCalculatorServiceServer) PerformCalculation(context.Context, *CalcMessage) (*CalcMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PerformCalculation not implemented")
+}
+func (UnimplementedCalculatorServiceServer) PerformCalculationBatch(grpc.ClientStreamingServer[CalcMessage, CalcBatchResult]) error {
+	return status.Errorf(codes.Unimplemented, "method PerformCalculationBatch not implemented")
+}
+func (UnimplementedCalculatorServiceServer) Ack(context.Context, *AckRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
 func (UnimplementedCalculatorServiceServer) mustEmbedUnimplementedCalculatorServiceServer() {}
 func (UnimplementedCalculatorServiceServer) testEmbeddedByValue()                           {}
 
@@ -167,6 +224,49 @@ func _CalculatorService_PerformCalculationFrom_Handler(srv interface{}, stream g
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type CalculatorService_PerformCalculationFromServer = grpc.ServerStreamingServer[CalcMessage]
 
+func _CalculatorService_PerformCalculation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalcMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).PerformCalculation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_PerformCalculation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).PerformCalculation(ctx, req.(*CalcMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CalculatorService_PerformCalculationBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CalculatorServiceServer).PerformCalculationBatch(&grpc.GenericServerStream[CalcMessage, CalcBatchResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CalculatorService_PerformCalculationBatchServer = grpc.ClientStreamingServer[CalcMessage, CalcBatchResult]
+
+func _CalculatorService_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServiceServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CalculatorService_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServiceServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CalculatorService_ServiceDesc is the grpc.ServiceDesc for CalculatorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -178,6 +278,14 @@ var CalculatorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "performCalculationTo",
 			Handler:    _CalculatorService_PerformCalculationTo_Handler,
 		},
+		{
+			MethodName: "performCalculation",
+			Handler:    _CalculatorService_PerformCalculation_Handler,
+		},
+		{
+			MethodName: "ack",
+			Handler:    _CalculatorService_Ack_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -191,6 +299,117 @@ var CalculatorService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _CalculatorService_PerformCalculationFrom_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "performCalculationBatch",
+			Handler:       _CalculatorService_PerformCalculationBatch_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "calculator.proto",
+}
+
+const (
+	ClusterService_Deliver_FullMethodName = "/calculator.ClusterService/deliver"
+)
+
+// ClusterServiceClient is the client API for ClusterService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ClusterService is spoken between the servers of a cluster.
+type ClusterServiceClient interface {
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error)
+}
+
+type clusterServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClusterServiceClient(cc grpc.ClientConnInterface) ClusterServiceClient {
+	return &clusterServiceClient{cc}
+}
+
+func (c *clusterServiceClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeliverResponse)
+	err := c.cc.Invoke(ctx, ClusterService_Deliver_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClusterServiceServer is the server API for ClusterService service.
+// All implementations must embed UnimplementedClusterServiceServer
+// for forward compatibility.
+//
+// ClusterService is spoken between the servers of a cluster.
+type ClusterServiceServer interface {
+	Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error)
+	mustEmbedUnimplementedClusterServiceServer()
+}
+
+// UnimplementedClusterServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedClusterServiceServer struct{}
+
+func (UnimplementedClusterServiceServer) Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deliver not implemented")
+}
+func (UnimplementedClusterServiceServer) mustEmbedUnimplementedClusterServiceServer() {}
+func (UnimplementedClusterServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeClusterServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClusterServiceServer will
+// result in compilation errors.
+type UnsafeClusterServiceServer interface {
+	mustEmbedUnimplementedClusterServiceServer()
+}
+
+func RegisterClusterServiceServer(s grpc.ServiceRegistrar, srv ClusterServiceServer) {
+	// If the following call pancis, it indicates UnimplementedClusterServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ClusterService_ServiceDesc, srv)
+}
+
+func _ClusterService_Deliver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeliverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).Deliver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClusterService_Deliver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).Deliver(ctx, req.(*DeliverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ClusterService_ServiceDesc is the grpc.ServiceDesc for ClusterService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ClusterService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calculator.ClusterService",
+	HandlerType: (*ClusterServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "deliver",
+			Handler:    _ClusterService_Deliver_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "calculator.proto",
 }
@@ -3,11 +3,23 @@
 # This script stops any running "server" process and restarts it.
 # It assumes the server binary is located in the user's home directory as "server".
 
-# Kill any running server process; ignore errors if none are running.
-pkill -f server || true
+# How long to wait for the old server to drain before killing it. Keep it
+# above the server's -drain-timeout (10s by default).
+DRAIN_WAIT=${DRAIN_WAIT:-15}
 
-# Wait for a moment to ensure the old process has terminated.
-sleep 1
+# Ask any running server to shut down gracefully; ignore errors if none are running.
+pkill -TERM -x server || true
+
+# Wait for the old process to drain its streams and exit.
+for ((i = 0; i < DRAIN_WAIT * 10; i++)); do
+    pgrep -x server > /dev/null || break
+    sleep 0.1
+done
+if pgrep -x server > /dev/null; then
+    echo "Server did not stop within ${DRAIN_WAIT}s, killing it."
+    pkill -KILL -x server || true
+    sleep 1
+fi
 
 # Restart the server in the background.
 # The output is redirected to server.log.